@@ -20,3 +20,15 @@ type Action struct {
 	DeviceURL DeviceURL `json:"deviceURL,omitempty"`
 	Commands  []Command `json:"commands,omitempty"`
 }
+
+// Execution is an action group currently being executed
+type Execution struct {
+	ID               ExecID      `json:"id,omitempty"`
+	Description      string      `json:"description,omitempty"`
+	Owner            string      `json:"owner,omitempty"`
+	State            string      `json:"state,omitempty"`
+	StartTime        int         `json:"startTime,omitempty"`
+	ExecutionType    string      `json:"executionType,omitempty"`
+	ExecutionSubType string      `json:"executionSubType,omitempty"`
+	ActionGroup      ActionGroup `json:"actionGroup,omitempty"`
+}
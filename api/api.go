@@ -22,52 +22,75 @@ import (
 // Client provides methods to make http requests to the api server while making the
 // authentification and session ID renewal transparent.
 type Client struct {
-	username string
-	password string
-	baseURL  string
-	hc       *http.Client
+	username  string
+	password  string
+	baseURL   string
+	hc        *http.Client
+	logger    Logger
+	sessions  SessionStore
+	userAgent string
+	timeout   time.Duration
+	retry     RetryPolicy
 
 	mux        sync.Mutex
 	listenerID string
+	stats      Stats
 }
 
-// New returns a new Client
-// sessionID is optional and used when caching sessions externally
-func New(username, password, baseURL, sessionID string) (*Client, error) {
-	hc := http.Client{
-		Timeout: time.Second * 10,
+// NewClient returns a new Client configured with the options. WithBaseURL is required.
+func NewClient(opts ...Option) (*Client, error) {
+	c := Client{
+		logger:    NopLogger{},
+		userAgent: DefaultUserAgent,
 	}
-	return NewWithHTTPClient(username, password, baseURL, sessionID, &hc)
-}
-
-// NewWithHTTPClient returns a new Client, injecting the HTTP client to use. See New.
-func NewWithHTTPClient(username, password, baseURL, sessionID string, hc *http.Client) (*Client, error) {
-	if baseURL == "" {
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.baseURL == "" {
 		return nil, errors.New("baseURL cannot be empty")
 	}
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return nil, err
+	}
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		return nil, err
 	}
-	url, err := url.Parse(baseURL)
-	if sessionID != "" {
-		cookie := http.Cookie{
-			Name:  "JSESSIONID",
-			Value: sessionID,
-		}
+	if c.sessions != nil {
+		sessionID, err := c.sessions.Load()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Error loading session: %w", err)
+		}
+		if sessionID != "" {
+			jar.SetCookies(u, []*http.Cookie{{Name: "JSESSIONID", Value: sessionID}})
 		}
-		jar.SetCookies(url, []*http.Cookie{&cookie})
 	}
-	hc.Jar = jar
-	client := Client{
-		username: username,
-		password: password,
-		baseURL:  baseURL,
-		hc:       hc,
+	hc := http.Client{Timeout: DefaultTimeout}
+	if c.hc != nil {
+		hc = *c.hc
+	}
+	if c.timeout > 0 {
+		hc.Timeout = c.timeout
 	}
-	return &client, nil
+	hc.Jar = jar
+	c.hc = &hc
+	return &c, nil
+}
+
+// New returns a new Client
+// sessionID is optional and used when caching sessions externally
+func New(username, password, baseURL, sessionID string, opts ...Option) (*Client, error) {
+	return NewClient(append([]Option{
+		WithCredentials(username, password),
+		WithBaseURL(baseURL),
+		WithSessionStore(NewMemorySessionStore(sessionID)),
+	}, opts...)...)
+}
+
+// NewWithHTTPClient returns a new Client, injecting the HTTP client to use. See New.
+func NewWithHTTPClient(username, password, baseURL, sessionID string, hc *http.Client, opts ...Option) (*Client, error) {
+	return New(username, password, baseURL, sessionID, append([]Option{WithHTTPClient(hc)}, opts...)...)
 }
 
 // SessionID is the latest known sessionID value
@@ -87,16 +110,30 @@ func (c *Client) SessionID() string {
 // This is normally called automatically from the methods that need it
 func (c *Client) Login() error {
 	formData := url.Values{"userId": {c.username}, "userPassword": {c.password}}
-	resp, err := c.hc.PostForm(c.baseURL+"/enduserAPI/login", formData)
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/enduserAPI/login", strings.NewReader(formData.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.mux.Lock()
+	c.stats.Logins++
+	c.mux.Unlock()
+	resp, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("Error logging in: %w", err)
 	}
 	defer resp.Body.Close()
 	if err := checkStatusOk(resp); err != nil {
+		c.countError(ErrorType(err))
 		return err
 	}
 	for _, cookie := range resp.Cookies() {
 		if (cookie.Name == "JSESSIONID") && (cookie.Value != "") {
+			if c.sessions != nil {
+				if err := c.sessions.Save(cookie.Value); err != nil {
+					c.logger.Warn("Unable to save the session", "err", err)
+				}
+			}
 			return nil
 		}
 	}
@@ -116,18 +153,28 @@ func (c *Client) GetWithAuth(query string) (*http.Response, error) {
 // DoWithAuth performs the given request. If an authentication error occurs,
 // it tries to login to renew the sessionID, then tries the request again.
 func (c *Client) DoWithAuth(req *http.Request) (*http.Response, error) {
-	req.Header.Set("User-Agent", "overkiz/1.0")
-	resp, err := c.hc.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	if err := checkStatusOk(resp); err != nil {
+		c.countError(ErrorType(err))
 		switch err.(type) {
 		case *AuthenticationError:
 			if err := c.Login(); err != nil {
 				return nil, err
 			}
-			resp, err := c.hc.Do(req)
+			// the jar adds the new session cookie, drop the expired one
+			req.Header.Del("Cookie")
+			if req.GetBody != nil {
+				// the body was consumed by the first attempt
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+			resp, err := c.do(req)
 			if err != nil {
 				return nil, err
 			}
@@ -139,6 +186,51 @@ func (c *Client) DoWithAuth(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+// do sends the request with the http client, counts and logs it.
+// Failed requests are sent again as allowed by the retry policy.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for retry := 0; ; retry++ {
+		c.mux.Lock()
+		c.stats.Calls++
+		c.mux.Unlock()
+		c.logRequest(req)
+		start := time.Now()
+		resp, err := c.hc.Do(req)
+		c.logResponse(req, resp, err, time.Since(start))
+		if err != nil {
+			c.countError("transport")
+		}
+		if retry >= c.retry.MaxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		delay := c.retry.delay(retry + 1)
+		c.logger.Debug("Retrying request", "method", req.Method, "url", req.URL.String(), "delay", delay)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// GetSetup returns the raw response to retrieving the whole setup, including devices and places
+func (c *Client) GetSetup() (*http.Response, error) {
+	return c.GetWithAuth("/enduserAPI/setup")
+}
+
 // GetDevices returns the raw response to retrieving all devices
 func (c *Client) GetDevices() (*http.Response, error) {
 	return c.GetWithAuth("/enduserAPI/setup/devices")
@@ -190,6 +282,24 @@ func (c *Client) Execute(json []byte) (*http.Response, error) {
 	return resp, nil
 }
 
+// ExecuteActionGroup initiates the execution of the action group with the given OID
+func (c *Client) ExecuteActionGroup(oid string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/enduserAPI/exec/"+url.PathEscape(oid), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.DoWithAuth(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error executing action group. %w", err)
+	}
+	return resp, nil
+}
+
+// GetCurrentExecutions returns the raw response to retrieving the executions in progress
+func (c *Client) GetCurrentExecutions() (*http.Response, error) {
+	return c.GetWithAuth("/enduserAPI/exec/current")
+}
+
 // SetListenerID overrides the stored listenerID.
 func (c *Client) SetListenerID(listenerID string) {
 	c.mux.Lock()
@@ -274,6 +384,7 @@ func (c *Client) PollEvents() (*http.Response, error) {
 			if resp, err = c.pollEventsWithID(c.ListenerID()); err != nil {
 				return nil, fmt.Errorf("Error retrieving events with valid listener: %w", err)
 			}
+			return resp, nil
 		}
 		return nil, err
 	}
@@ -3,8 +3,12 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -190,6 +194,65 @@ func TestPollEventsDoesRegisterListener(t *testing.T) {
 	assert.Equal(t, validLID, c.ListenerID())
 }
 
+func TestPollEventsReturnsEventsAfterListenerRetry(t *testing.T) {
+	const validLID = "77777777-3333-5555-2222-cccccccccccc"
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.String() {
+		case "/enduserAPI/events/register":
+			rw.Write([]byte(`{"id":"` + validLID + `"}`))
+		case "/enduserAPI/events/" + validLID + "/fetch":
+			rw.Write([]byte(`[{"name":"GatewayAliveEvent"}]`))
+		default:
+			rw.WriteHeader(400)
+			rw.Write([]byte(`{"errorCode": "UNSPECIFIED_ERROR", "error": "No registered event listener"}`))
+		}
+	}))
+	defer server.Close()
+	c, err := NewWithHTTPClient("gooduser", "goodpass", server.URL, "", server.Client())
+	assert.NoError(t, err)
+	c.SetListenerID("expired_lid")
+	resp, err := c.PollEvents()
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) {
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `[{"name":"GatewayAliveEvent"}]`, string(body))
+	}
+}
+
+func TestExpiredSessionRetriesPostWithBody(t *testing.T) {
+	const payload = `{"actions":[]}`
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.String() {
+		case "/enduserAPI/login":
+			http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: "new_session", Path: "/"})
+			rw.Write([]byte(`{"success":true}`))
+		case "/enduserAPI/exec/apply":
+			attempts++
+			assert.Len(t, req.Cookies(), 1)
+			if cookie, err := req.Cookie("JSESSIONID"); err != nil || cookie.Value != "new_session" {
+				rw.WriteHeader(401)
+				rw.Write([]byte(`{"errorCode":"RESOURCE_ACCESS_DENIED","error":"Not authenticated"}`))
+				return
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, string(body))
+			rw.Write([]byte(`{"execId":"abc"}`))
+		}
+	}))
+	defer server.Close()
+	c, err := NewWithHTTPClient("user", "pass", server.URL, "expired_session", server.Client())
+	assert.NoError(t, err)
+	resp, err := c.Execute([]byte(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	if assert.NotNil(t, resp) {
+		resp.Body.Close()
+	}
+}
+
 func TestCheckStatusOk(t *testing.T) {
 	var tests = []struct {
 		name     string
@@ -215,3 +278,193 @@ func TestCheckStatusOk(t *testing.T) {
 		})
 	}
 }
+
+func TestStats(t *testing.T) {
+	loggedIn := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.String() == "/enduserAPI/login":
+			loggedIn = true
+			http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: "session"})
+			rw.Write([]byte(`{"success":true}`))
+		case !loggedIn:
+			rw.WriteHeader(401)
+			rw.Write([]byte(`{"errorCode":"RESOURCE_ACCESS_DENIED","error":"Not authenticated"}`))
+		default:
+			rw.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+	c, err := NewWithHTTPClient("user", "pass", server.URL, "", server.Client())
+	assert.NoError(t, err)
+	_, err = c.GetDevices()
+	assert.NoError(t, err)
+	stats := c.Stats()
+	assert.Equal(t, 3, stats.Calls)
+	assert.Equal(t, 1, stats.Logins)
+	assert.Equal(t, map[string]int{"authentication": 1}, stats.Errors)
+}
+
+func TestRecordReplay(t *testing.T) {
+	const oid = "SETUP-1234-5678-9012"
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.String() {
+		case "/enduserAPI/login":
+			http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: "secret-session", Path: "/"})
+			rw.Write([]byte(`{"success":true}`))
+		case "/enduserAPI/setup":
+			rw.Write([]byte(`{"oid":"` + oid + `","devices":[]}`))
+		case "/enduserAPI/setup/devices":
+			rw.Write([]byte(`[{"deviceURL":"io://` + oid + `/1","label":"Door","setupOID":"` + oid + `"}]`))
+		}
+	}))
+	defer server.Close()
+
+	recorder := NewRecorder(server.Client().Transport)
+	c, err := NewWithHTTPClient("user", "secret-password", server.URL, "", &http.Client{Transport: recorder})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Login())
+	_, err = c.GetSetup()
+	assert.NoError(t, err)
+	resp, err := c.GetDevices()
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	dir, err := ioutil.TempDir("", "cassette")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+	assert.NoError(t, recorder.Save(path))
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	for _, secret := range []string{"secret-password", "secret-session", oid} {
+		assert.NotContains(t, string(data), secret)
+	}
+
+	cassette, err := LoadCassette(path)
+	assert.NoError(t, err)
+	if !assert.Len(t, cassette.Interactions, 3) {
+		return
+	}
+	c, err = NewWithHTTPClient("user", "password", "http://offline.invalid", "", &http.Client{Transport: NewReplayer(cassette)})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Login())
+	assert.Equal(t, Redacted, c.SessionID())
+	resp, err = c.GetDevices()
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"deviceURL":"io://`+RedactedOID+`/1","label":"Door","setupOID":"`+RedactedOID+`"}]`, string(body))
+
+	_, err = c.GetDevices()
+	assert.Error(t, err, "each recorded response is replayed once")
+}
+
+// testLogger records the logged messages and their arguments
+type testLogger struct {
+	NopLogger
+	messages []string
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(msg, args))
+}
+
+func TestLoggerRedactsSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: "secret-session"})
+		rw.Write([]byte(`{"success":true}`))
+	}))
+	defer server.Close()
+	logger := &testLogger{}
+	c, err := NewWithHTTPClient("user", "secret-password", server.URL, "", server.Client(), WithLogger(logger))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Login())
+	assert.Len(t, logger.messages, 2)
+	for _, m := range logger.messages {
+		assert.NotContains(t, m, "secret")
+	}
+	assert.Contains(t, logger.messages[0], "HTTP request")
+	assert.Contains(t, logger.messages[1], "HTTP response")
+	assert.Contains(t, logger.messages[1], "200")
+}
+
+// quietLogger does not log debug messages
+type quietLogger struct {
+	testLogger
+}
+
+func (l *quietLogger) DebugEnabled() bool { return false }
+
+func TestLoggerWithoutDebug(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: "session"})
+		rw.Write([]byte(`{"success":true}`))
+	}))
+	defer server.Close()
+	logger := &quietLogger{}
+	c, err := NewWithHTTPClient("user", "password", server.URL, "", server.Client(), WithLogger(logger))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Login())
+	assert.Empty(t, logger.messages)
+}
+
+func TestNewClientOptions(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		userAgent = req.UserAgent()
+		http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: "new-session"})
+		rw.Write([]byte(`{"success":true}`))
+	}))
+	defer server.Close()
+	hc := server.Client()
+	store := NewMemorySessionStore("old-session")
+	c, err := NewClient(
+		WithCredentials("user", "pass"),
+		WithBaseURL(server.URL),
+		WithHTTPClient(hc),
+		WithSessionStore(store),
+		WithUserAgent("kiztest/1.0"),
+		WithTimeout(time.Second),
+	)
+	assert.NoError(t, err)
+	assert.Nil(t, hc.Jar, "the http client is not changed")
+	assert.Equal(t, time.Duration(0), hc.Timeout)
+	assert.Equal(t, "old-session", c.SessionID())
+
+	assert.NoError(t, c.Login())
+	assert.Equal(t, "kiztest/1.0", userAgent)
+	sessionID, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "new-session", sessionID)
+
+	_, err = NewClient(WithCredentials("user", "pass"))
+	assert.Error(t, err, "the base url is required")
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls%3 != 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	c, err := NewClient(WithBaseURL(server.URL), WithHTTPClient(server.Client()),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}))
+	assert.NoError(t, err)
+	_, err = c.GetDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	_, err = c.Execute([]byte(`{}`))
+	assert.Error(t, err, "executions are not retried")
+	assert.Equal(t, 1, calls)
+
+	assert.Equal(t, 4*time.Millisecond, RetryPolicy{Backoff: time.Millisecond}.delay(3))
+	assert.Equal(t, 3*time.Millisecond, RetryPolicy{Backoff: time.Millisecond, MaxBackoff: 3 * time.Millisecond}.delay(5))
+}
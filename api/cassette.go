@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in cassettes
+const Redacted = "REDACTED"
+
+// RedactedOID replaces setup OIDs in cassettes
+const RedactedOID = "00000000-0000-0000-0000-000000000000"
+
+// Cassette holds recorded http interactions with the server
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response of the server
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request sent to the server. URL is the path and query, so that cassettes
+// can be replayed with any base url.
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response of the server
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// LoadCassette reads a cassette from a json file
+func LoadCassette(path string) (Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Cassette{}, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Cassette{}, fmt.Errorf("Error parsing cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the cassette to a json file
+func (c Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Recorder is a http.RoundTripper recording the interactions with the server, with
+// credentials, cookies and setup OIDs redacted. It is safe for concurrent use.
+type Recorder struct {
+	transport http.RoundTripper
+
+	mux          sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a recorder sending the requests with the transport, http.DefaultTransport if nil
+func NewRecorder(transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{transport: transport}
+}
+
+// RoundTrip sends the request and records it with its response
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+			Body:   redactForm(string(reqBody)),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     redactHeader(resp.Header),
+			Body:       string(respBody),
+		},
+	})
+	return resp, nil
+}
+
+// Cassette returns the interactions recorded so far, with setup OIDs redacted
+func (r *Recorder) Cassette() Cassette {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	c := Cassette{Interactions: append([]Interaction(nil), r.interactions...)}
+	redactOIDs(&c)
+	return c
+}
+
+// Save writes the interactions recorded so far to a json file
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+// redactForm redacts the credentials of a login form
+func redactForm(body string) string {
+	form, err := url.ParseQuery(body)
+	if err != nil || (form.Get("userId") == "" && form.Get("userPassword") == "") {
+		return body
+	}
+	for _, key := range []string{"userId", "userPassword"} {
+		if _, ok := form[key]; ok {
+			form.Set(key, Redacted)
+		}
+	}
+	return form.Encode()
+}
+
+// redactHeader returns a copy of the header with the cookie values redacted
+func redactHeader(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for k, values := range h {
+		if k != "Set-Cookie" {
+			redacted[k] = values
+			continue
+		}
+		for _, v := range values {
+			if i := strings.Index(v, "="); i >= 0 {
+				end := strings.Index(v, ";")
+				if end < 0 {
+					end = len(v)
+				}
+				v = v[:i+1] + Redacted + v[end:]
+			}
+			redacted.Add(k, v)
+		}
+	}
+	return redacted
+}
+
+var setupOIDPattern = regexp.MustCompile(`"setupOID"\s*:\s*"([^"]+)"`)
+
+// redactOIDs replaces the setup OIDs found in the responses, everywhere in the cassette
+func redactOIDs(c *Cassette) {
+	oids := make(map[string]bool)
+	for _, i := range c.Interactions {
+		for _, m := range setupOIDPattern.FindAllStringSubmatch(i.Response.Body, -1) {
+			oids[m[1]] = true
+		}
+		if i.Request.URL == "/enduserAPI/setup" {
+			var setup struct{ OID string }
+			if json.Unmarshal([]byte(i.Response.Body), &setup) == nil && setup.OID != "" {
+				oids[setup.OID] = true
+			}
+		}
+	}
+	for oid := range oids {
+		for k, i := range c.Interactions {
+			c.Interactions[k].Request.URL = strings.Replace(i.Request.URL, oid, RedactedOID, -1)
+			c.Interactions[k].Request.Body = strings.Replace(i.Request.Body, oid, RedactedOID, -1)
+			c.Interactions[k].Response.Body = strings.Replace(i.Response.Body, oid, RedactedOID, -1)
+		}
+	}
+}
+
+// Replayer is a http.RoundTripper answering requests with the responses of a cassette, without
+// contacting any server. Each request gets the first unused response recorded for the same method
+// and url. It is safe for concurrent use.
+type Replayer struct {
+	mux          sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer returns a replayer of the cassette
+func NewReplayer(c Cassette) *Replayer {
+	return &Replayer{interactions: c.Interactions, used: make([]bool, len(c.Interactions))}
+}
+
+// RoundTrip returns the recorded response to the request, or an error if there is none
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	uri := req.URL.RequestURI()
+	for k, i := range r.interactions {
+		if r.used[k] || i.Request.Method != req.Method || i.Request.URL != uri {
+			continue
+		}
+		r.used[k] = true
+		header := make(http.Header, len(i.Response.Header))
+		for name, values := range i.Response.Header {
+			header[name] = append([]string(nil), values...)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+			StatusCode:    i.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(i.Response.Body)),
+			ContentLength: int64(len(i.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("No recorded response for %s %s", req.Method, uri)
+}
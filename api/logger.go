@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Logger receives log messages with alternating keys and values, e.g.
+// Debug("HTTP response", "status", 200). A *slog.Logger implements it.
+// The packages of kizcool log to a NopLogger, that is nothing, unless given a logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards all messages
+type NopLogger struct{}
+
+// Debug discards the message
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+
+// Info discards the message
+func (NopLogger) Info(msg string, args ...interface{}) {}
+
+// Warn discards the message
+func (NopLogger) Warn(msg string, args ...interface{}) {}
+
+// Error discards the message
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// DebugLogger is a Logger that tells whether debug messages are logged. The client only reads
+// bodies for logging when they are, and logs them always with loggers that do not implement it.
+type DebugLogger interface {
+	Logger
+	DebugEnabled() bool
+}
+
+// Logger returns the logger of the client
+func (c *Client) Logger() Logger {
+	return c.logger
+}
+
+// maxLoggedBody is the size above which logged bodies are truncated
+const maxLoggedBody = 2048
+
+// logRequest logs a request sent to the server. The body is restored for sending.
+func (c *Client) logRequest(req *http.Request) {
+	if !c.debugEnabled() {
+		return
+	}
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	c.logger.Debug("HTTP request", "method", req.Method, "url", req.URL.String(), "body", truncate(redactForm(string(body))))
+}
+
+// logResponse logs a response of the server. The body is restored for reading.
+func (c *Client) logResponse(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	if !c.debugEnabled() {
+		return
+	}
+	if err != nil {
+		c.logger.Debug("HTTP error", "method", req.Method, "url", req.URL.String(), "err", err, "duration", elapsed)
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	c.logger.Debug("HTTP response", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode,
+		"duration", elapsed, "body", truncate(string(body)))
+}
+
+// debugEnabled tells whether the logger of the client logs debug messages
+func (c *Client) debugEnabled() bool {
+	switch l := c.logger.(type) {
+	case NopLogger:
+		return false
+	case DebugLogger:
+		return l.DebugEnabled()
+	}
+	return true
+}
+
+// truncate shortens a logged body
+func truncate(body string) string {
+	if len(body) > maxLoggedBody {
+		return body[:maxLoggedBody] + "..."
+	}
+	return body
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of NewClient
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultUserAgent = "overkiz/1.0"
+)
+
+// Option configures a Client, see NewClient
+type Option func(*Client)
+
+// WithCredentials sets the username and password used to login
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithBaseURL sets the url of the server, e.g. https://tahomalink.com/enduser-mobile-web. It is required.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient sets the http client used to send requests, e.g. with another transport.
+// The client is copied, so that setting the cookie jar does not change it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithSessionStore sets where the session ID is loaded from when the client is created,
+// and saved to after each login. Sessions are not kept by default.
+func WithSessionStore(store SessionStore) Option {
+	return func(c *Client) {
+		c.sessions = store
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests, DefaultUserAgent by default
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithTimeout sets the time limit of each request, DefaultTimeout by default or the timeout of the
+// client given with WithHTTPClient.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithLogger makes the client log http requests and responses at debug level, with credentials
+// and cookies redacted.
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		if l == nil {
+			l = NopLogger{}
+		}
+		c.logger = l
+	}
+}
+
+// WithRetryPolicy makes the client retry failed requests, see RetryPolicy. Requests are not retried by default.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// RetryPolicy tells how requests failing with a network error or a 502, 503 or 504 status are retried.
+// Only idempotent requests, e.g. GET or PUT, are retried: executions and event polls are sent once.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubled for each following one
+	Backoff time.Duration
+	// MaxBackoff limits the delay between retries if not zero
+	MaxBackoff time.Duration
+}
+
+// delay returns the delay before the given retry, starting at 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// retryable tells if the request can be sent again after the response or error
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+	default:
+		return false
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// SessionStore keeps the session ID between runs, e.g. in a file
+type SessionStore interface {
+	// Load returns the saved session ID, or an empty string if there is none
+	Load() (string, error)
+	// Save saves the session ID obtained by a login
+	Save(sessionID string) error
+}
+
+// MemorySessionStore keeps the session ID in memory. It is safe for concurrent use.
+type MemorySessionStore struct {
+	mux       sync.Mutex
+	sessionID string
+}
+
+// NewMemorySessionStore returns a store holding the session ID
+func NewMemorySessionStore(sessionID string) *MemorySessionStore {
+	return &MemorySessionStore{sessionID: sessionID}
+}
+
+// Load returns the session ID
+func (s *MemorySessionStore) Load() (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.sessionID, nil
+}
+
+// Save stores the session ID
+func (s *MemorySessionStore) Save(sessionID string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.sessionID = sessionID
+	return nil
+}
@@ -0,0 +1,46 @@
+package api
+
+// Stats are counters of the requests made by a Client since its creation
+type Stats struct {
+	Calls  int            // requests sent to the server, including logins
+	Logins int            // login attempts
+	Errors map[string]int // errors by type, see ErrorType
+}
+
+// ErrorType returns a short name for the kind of error, used to count errors:
+// authentication, too_many_requests, no_listener, json or other.
+func ErrorType(err error) string {
+	switch err.(type) {
+	case *AuthenticationError:
+		return "authentication"
+	case *TooManyRequestsError:
+		return "too_many_requests"
+	case *NoRegisteredEventListenerError:
+		return "no_listener"
+	case *JSONError:
+		return "json"
+	}
+	return "other"
+}
+
+// Stats returns a copy of the request counters
+func (c *Client) Stats() Stats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	s := c.stats
+	s.Errors = make(map[string]int, len(c.stats.Errors))
+	for k, v := range c.stats.Errors {
+		s.Errors[k] = v
+	}
+	return s
+}
+
+// countError increments the error counter for the given type
+func (c *Client) countError(errorType string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.stats.Errors == nil {
+		c.stats.Errors = make(map[string]int)
+	}
+	c.stats.Errors[errorType]++
+}
@@ -0,0 +1,80 @@
+package kizcool
+
+import (
+	"context"
+
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Client is the high-level client to an installation. Kiz implements it against the Overkiz api,
+// applications can depend on it to be tested with a fake, see package kizcooltest.
+type Client interface {
+	// Devices
+	GetSetup() (Setup, error)
+	GetDevices() ([]Device, error)
+	GetDevice(deviceURL DeviceURL) (Device, error)
+	GetDeviceByText(text string) (Device, error)
+	GetDevicesByText(text string) ([]Device, error)
+
+	// States
+	GetDeviceState(deviceURL DeviceURL, stateName StateName) (DeviceState, error)
+	RefreshStates() error
+	RefreshDeviceStates(ctx context.Context, device Device, stateNames ...StateName) error
+	WaitForState(ctx context.Context, device Device, stateName StateName, predicate StatePredicate) (DeviceState, error)
+
+	// Executions
+	Execute(ag ActionGroup) (ExecID, error)
+	GetCurrentExecutions() ([]Execution, error)
+	On(device Device) (ExecID, error)
+	Off(device Device) (ExecID, error)
+	Open(device Device) (ExecID, error)
+	Close(device Device) (ExecID, error)
+	Stop(device Device) (ExecID, error)
+	SetIntensity(device Device, intensity int) (ExecID, error)
+	SetClosure(device Device, position int) (ExecID, error)
+
+	// Action groups
+	GetActionGroups() ([]ActionGroup, error)
+	ExecuteActionGroup(oid string) (ExecID, error)
+
+	// Events
+	PollEvents() (Events, error)
+	PollEventsContinuous(ev chan<- Event, e chan<- error, finish <-chan struct{})
+
+	// Stats returns the usage of the api
+	Stats() api.Stats
+}
+
+var _ Client = (*Kiz)(nil)
+
+// ListenEvents polls the events of the client in a goroutine until the context is done, then closes
+// the returned channel. Polling errors are logged to logger and polling resumes after a pause.
+func ListenEvents(ctx context.Context, c Client, logger api.Logger) <-chan Event {
+	if logger == nil {
+		logger = api.NopLogger{}
+	}
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		events := make(chan Event)
+		errs := make(chan error)
+		finish := make(chan struct{})
+		defer close(finish)
+		go c.PollEventsContinuous(events, errs, finish)
+		for {
+			select {
+			case err := <-errs:
+				logger.Error("Polling error, will resume after a pause.", "err", err)
+			case event := <-events:
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/rules"
+	"github.com/spf13/cobra"
+)
+
+var automateCmd = &cobra.Command{
+	Use:   "automate",
+	Short: "Run automation rules",
+	Long: `Run the automation rules of a yaml file until interrupted. Rules trigger on state changes,
+	gateway up or down and times of the day, check conditions on device states and run commands or scenarios.
+	See the rules package documentation for the file format.
+	kizcmd automate rules.yaml --dry-run`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("You must specify a rules file")
+		}
+		cfg, err := rules.LoadFile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		setup, err := kiz.GetSetup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		scenarios, err := kiz.GetActionGroups()
+		if err != nil {
+			log.Fatal(err)
+		}
+		engine, err := rules.New(cfg, setup, scenarios, kiz, rules.WithLogger(logger{}))
+		if err != nil {
+			log.Fatal(err)
+		}
+		engine.DryRun = dryRun
+		log.Infof("Running %d rules", len(cfg.Rules))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+		engine.Run(ctx, kizcool.ListenEvents(ctx, kiz, logger{}))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(automateCmd)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	log "github.com/sirupsen/logrus"
 
+	"github.com/sgrimee/kizcool"
 	"github.com/spf13/cobra"
 )
 
@@ -11,19 +12,12 @@ var closeCmd = &cobra.Command{
 	Use:   "close",
 	Short: "Close device",
 	Long: `Close the device.
-	The first argument is the device url or label.`,
+	The first argument is the device url, label or selector.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			log.Fatal("You must specify a device.")
 		}
-		dev, err := kiz.GetDeviceByText(args[0])
-		if err != nil {
-			log.Fatal(err)
-		}
-		_, err = kiz.Close(dev)
-		if err != nil {
-			log.Fatal(err)
-		}
+		execute(args[0], kizcool.Command{Name: kizcool.CmdClose})
 	},
 }
 
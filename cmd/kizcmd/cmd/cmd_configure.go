@@ -44,7 +44,7 @@ var configureCmd = &cobra.Command{
 		}
 		config.SetUsername(username)
 		config.SetPassword(password)
-		kiz, err = kizcool.New(username, password, config.BaseURL(), "")
+		kiz, err = kizcool.New(username, password, config.BaseURL(), "", kizcool.WithLogger(logger{}))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool/exporter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exporterListen string
+	exporterPath   string
+)
+
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Expose device states as prometheus metrics",
+	Long: `Serve device states, device and gateway availability and api client statistics
+	as prometheus metrics. States are kept up to date by listening to events.
+	kizcmd exporter --listen :9100`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exp, err := exporter.New(kiz, exporter.WithLogger(logger{}))
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(exporterPath, exp)
+		server := &http.Server{Addr: exporterListen, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		log.Infof("Serving metrics on %s%s", exporterListen, exporterPath)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+		exp.Run(ctx)
+		server.Close()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exporterCmd)
+	exporterCmd.Flags().StringVar(&exporterListen, "listen", ":9100", "Address to listen on")
+	exporterCmd.Flags().StringVar(&exporterPath, "path", "/metrics", "Path of the metrics")
+}
@@ -2,8 +2,6 @@ package cmd
 
 import "github.com/spf13/cobra"
 
-var outputFormat string // set by command-line parameter
-
 // getCmd represents the get command
 var getCmd = &cobra.Command{
 	Use:   "get",
@@ -13,5 +11,4 @@ var getCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(getCmd)
-	getCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "Output format: text, json or yaml")
 }
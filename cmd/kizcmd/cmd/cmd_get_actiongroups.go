@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var actionGroupsCmd = &cobra.Command{
+	Use:     "actiongroups",
+	Aliases: []string{"scenarios"},
+	Short:   "Get all action groups",
+	Long:    "Get list of all action groups (scenarios) defined on the box.",
+	Run: func(cmd *cobra.Command, args []string) {
+		actionGroups, err := kiz.GetActionGroups()
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(outputFormat, actionGroups)
+	},
+}
+
+func init() {
+	getCmd.AddCommand(actionGroupsCmd)
+}
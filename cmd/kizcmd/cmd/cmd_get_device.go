@@ -9,17 +9,20 @@ import (
 var deviceCmd = &cobra.Command{
 	Use:   "device",
 	Short: "Get a single device",
-	Long: `Get infos on a single device identified by its device url or label (case insensitive)
-	kizcmd get device "io://1111-0000-4444/15332221"`,
+	Long: `Get infos on a device identified by its device url or label (case insensitive),
+	or on all the devices matching a selector.
+	kizcmd get device "io://1111-0000-4444/15332221"
+	kizcmd get device 'class=RollerShutter and room=Bedroom'`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			log.Fatal("You must specify a device.")
 		}
-		dev, err := kiz.GetDeviceByText(args[0])
-		if err != nil {
-			log.Fatal(err)
+		devices := devicesFromText(args[0])
+		if len(devices) == 1 {
+			output(outputFormat, devices[0])
+			return
 		}
-		output(outputFormat, dev)
+		output(outputFormat, devices)
 	},
 }
 
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var executionsCmd = &cobra.Command{
+	Use:   "executions",
+	Short: "Get executions in progress",
+	Long:  "Get list of the action groups currently being executed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		executions, err := kiz.GetCurrentExecutions()
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(outputFormat, executions)
+	},
+}
+
+func init() {
+	getCmd.AddCommand(executionsCmd)
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var setupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Get the setup",
+	Long:  "Get the whole setup: location, gateways, devices and places.",
+	Run: func(cmd *cobra.Command, args []string) {
+		setup, err := kiz.GetSetup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(outputFormat, setup)
+	},
+}
+
+func init() {
+	getCmd.AddCommand(setupCmd)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statesRaw     bool // set by command-line parameter
+	statesRefresh bool // set by command-line parameter
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Get states of a device",
+	Long: `Get the value of the given states of a device, or all its states if none is given.
+	The first argument is the device url or label, the others are state names.
+	kizcmd get state "my window" core:OpenClosedState --raw`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatal("You must specify a device.")
+		}
+		var names []kizcool.StateName
+		for _, a := range args[1:] {
+			names = append(names, kizcool.StateName(a))
+		}
+		outputStates(args[0], names)
+	},
+}
+
+var statesCmd = &cobra.Command{
+	Use:   "states",
+	Short: "Get all states of a device",
+	Long: `Get the value of all the states of a device.
+	kizcmd get states "my window"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("You must specify a device.")
+		}
+		outputStates(args[0], nil)
+	},
+}
+
+// outputStates prints the named states of the device, or all its states if names is empty
+func outputStates(text string, names []kizcool.StateName) {
+	const refreshTimeout = 30 * time.Second
+	var err error
+	dev := deviceFromText(text)
+	if statesRefresh {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		err := kiz.RefreshDeviceStates(ctx, dev, names...)
+		if _, ok := err.(*kizcool.UnrefreshedStatesError); ok || errors.Is(err, kizcool.ErrNoRefreshCommand) {
+			log.Warnf("%s, reading the current value", err)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var states []kizcool.DeviceState
+	if len(names) == 0 {
+		if dev, err = kiz.GetDevice(dev.DeviceURL); err != nil {
+			log.Fatal(err)
+		}
+		states = dev.States
+	}
+	for _, name := range names {
+		state, err := kiz.GetDeviceState(dev.DeviceURL, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		states = append(states, state)
+	}
+	if statesRaw {
+		for _, s := range states {
+			fmt.Println(s.Value)
+		}
+		return
+	}
+	output(outputFormat, states)
+}
+
+func init() {
+	for _, c := range []*cobra.Command{stateCmd, statesCmd} {
+		getCmd.AddCommand(c)
+		c.Flags().BoolVar(&statesRaw, "raw", false, "Print only the values, one per line")
+		c.Flags().BoolVar(&statesRefresh, "refresh", false, "Ask the device to refresh the states before reading them")
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// groupCmd represents the group command
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Show device groups",
+	Long: `Show the device groups declared under 'groups' in the config file. Members are device urls,
+	labels or selectors, or other groups prefixed with @. Any command taking a device accepts @group.
+	groups:
+	  upstairs: [Bedroom blind, Office blind]
+	  house: ["@upstairs", Kitchen blind]`,
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the groups and check their members",
+	Long: `List the groups and check that all their members still exist on the box.
+	The command fails if a member is missing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		groups := kiz.Groups()
+		output(outputFormat, groups.List())
+		setup, err := kiz.GetSetup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := groups.Validate(setup); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var groupShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the devices of a group",
+	Long: `Show the devices of a group, including those of nested groups.
+	kizcmd group show upstairs`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("You must specify a group")
+		}
+		setup, err := kiz.GetSetup()
+		if err != nil {
+			log.Fatal(err)
+		}
+		devices, err := kiz.Groups().Devices(args[0], setup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(outputFormat, devices)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupShowCmd)
+}
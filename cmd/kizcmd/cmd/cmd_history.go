@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/history"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historySince  time.Duration
+	historyUntil  time.Duration
+	historyLimit  int
+	historyEvents bool
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show recorded state changes of a device",
+	Long: `Show the state changes of a device recorded with the record command, oldest first.
+	The first argument is the device url, label or selector, the others are state names.
+	With --events, the recorded events of the device are shown instead. SQLite needs kizcmd to be built with cgo.
+	kizcmd history "bedroom window" core:OpenClosedState --since 24h
+	kizcmd history "class=Window" --since 12h --until 2h -o table`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatal("You must specify a device.")
+		}
+		store, err := history.Open(historyDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+
+		var q history.Query
+		for _, dev := range devicesFromText(args[0]) {
+			q.DeviceURLs = append(q.DeviceURLs, dev.DeviceURL)
+		}
+		for _, a := range args[1:] {
+			q.States = append(q.States, kizcool.StateName(a))
+		}
+		if historySince > 0 {
+			q.Since = time.Now().Add(-historySince)
+		}
+		if historyUntil > 0 {
+			q.Until = time.Now().Add(-historyUntil)
+		}
+		q.Limit = historyLimit
+
+		if historyEvents {
+			events, err := store.Events(q)
+			if err != nil {
+				log.Fatal(err)
+			}
+			output(outputFormat, events)
+			return
+		}
+		changes, err := store.States(q)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(outputFormat, changes)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().StringVar(&historyDB, "db", "events.db", "Path of the SQLite database")
+	historyCmd.Flags().DurationVar(&historySince, "since", 24*time.Hour, "Only show changes more recent than this (0 for no limit)")
+	historyCmd.Flags().DurationVar(&historyUntil, "until", 0, "Only show changes older than this")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 0, "Only show this many of the most recent changes (0 for no limit)")
+	historyCmd.Flags().BoolVar(&historyEvents, "events", false, "Show the recorded events instead of the state changes")
+}
@@ -4,6 +4,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"strconv"
 
+	"github.com/sgrimee/kizcool"
 	"github.com/spf13/cobra"
 )
 
@@ -12,25 +13,20 @@ var intensityCmd = &cobra.Command{
 	Use:   "intensity",
 	Short: "Set device intensity",
 	Long: `Set device to given intensity.
-	The first argument is the device url or label.
+	The first argument is the device url, label or selector.
 	The second argument is the intensity in range 0-100`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 2 {
 			log.Fatal("You must specify a device and intensity")
 		}
-		dev, err := kiz.GetDeviceByText(args[0])
-		if err != nil {
-			log.Fatal(err)
-		}
 		intensity, err := strconv.Atoi(args[1])
-		if err != nil {
-			log.Fatal(err)
-
-		}
-		_, err = kiz.SetIntensity(dev, intensity)
 		if err != nil {
 			log.Fatal(err)
 		}
+		execute(args[0], kizcool.Command{
+			Name:       kizcool.CmdSetIntensity,
+			Parameters: []int{intensity},
+		})
 	},
 }
 
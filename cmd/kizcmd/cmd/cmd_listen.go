@@ -1,22 +1,105 @@
 package cmd
 
 import (
-	"fmt"
-	log "github.com/sirupsen/logrus"
+	"os"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/sink"
 	"github.com/spf13/cobra"
 )
 
+var (
+	listenDevices []string
+	listenEvents  []string
+	listenStates  []string
+	listenCount   int
+	listenTimeout time.Duration
+	listenRefresh bool
+	listenSinks   []string
+	listenQuiet   bool
+)
+
 var listenCmd = &cobra.Command{
 	Use:   "listen",
 	Short: "Listen for events",
-	Long:  "Continuously poll for events from the server and display them on the console.",
+	Long: `Continuously poll for events from the server and display them on the console.
+	Events are printed one per line in the format given by --output (NDJSON for json).
+	Events can also be sent to sinks: influx:<file>, influx://host:8086/write?db=home, ndjson:<file> or sqlite:<file> (needs cgo).
+	kizcmd listen -o json --device "my window" --state core:OpenClosedState --count 1
+	kizcmd listen --sink influx://localhost:8086/api/v2/write?org=home&bucket=kizcool&token=secret --quiet`,
 	Run: func(cmd *cobra.Command, arge []string) {
+		filter := kizcool.EventFilter{
+			Names: listenEvents,
+		}
+		for _, text := range listenDevices {
+			for _, dev := range devicesFromText(text) {
+				filter.DeviceURLs = append(filter.DeviceURLs, dev.DeviceURL)
+			}
+		}
+		for _, s := range listenStates {
+			filter.States = append(filter.States, kizcool.StateName(s))
+		}
+
+		var sinks sink.Multi
+		for _, spec := range listenSinks {
+			s, err := sink.Open(spec)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sinks = append(sinks, s)
+		}
+		defer sinks.Close()
+
+		var timeout <-chan time.Time
+		if listenTimeout > 0 {
+			timeout = time.After(listenTimeout)
+		}
+
+		printer := kizcool.NewPrinter(os.Stdout, outputFormat)
+		received := 0
+		// show returns true when enough events have been printed
+		show := func(event kizcool.Event) bool {
+			event, ok := filter.Filter(event)
+			if !ok {
+				return false
+			}
+			if err := sinks.Record(event); err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("Error recording event")
+			}
+			if !listenQuiet {
+				if err := printer.Print(event); err != nil {
+					log.Fatal(err)
+				}
+			}
+			received++
+			return listenCount > 0 && received >= listenCount
+		}
+
+		// the first poll registers the listener, so that events caused by the refresh are not lost
+		initial, err := kiz.PollEvents()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, event := range initial {
+			if show(event) {
+				return
+			}
+		}
+		if listenRefresh {
+			if err := kiz.RefreshStates(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
 		events := make(chan kizcool.Event)
 		finish := make(chan struct{})
 		e := make(chan error)
+		defer close(finish)
 
 		go kiz.PollEventsContinuous(events, e, finish)
 
@@ -27,12 +110,11 @@ var listenCmd = &cobra.Command{
 					"err": err,
 				}).Error("Polling error, will resume after a pause.")
 			case event := <-events:
-				log.WithFields(log.Fields{
-					"event": event,
-					"type":  fmt.Sprintf("%T", event),
-				}).Info("Received event")
-			default:
-				time.Sleep(time.Millisecond * 100) // avoid burning the CPU
+				if show(event) {
+					return
+				}
+			case <-timeout:
+				return
 			}
 		}
 	},
@@ -40,4 +122,12 @@ var listenCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(listenCmd)
+	listenCmd.Flags().StringSliceVar(&listenDevices, "device", nil, "Only show events for these devices (url, label or selector)")
+	listenCmd.Flags().StringSliceVar(&listenEvents, "event", nil, "Only show events with these names, e.g. DeviceStateChangedEvent")
+	listenCmd.Flags().StringSliceVar(&listenStates, "state", nil, "Only show changes of these states, e.g. core:ClosureState")
+	listenCmd.Flags().IntVar(&listenCount, "count", 0, "Exit after this many events (0 for no limit)")
+	listenCmd.Flags().DurationVar(&listenTimeout, "timeout", 0, "Exit after this duration (0 for no limit)")
+	listenCmd.Flags().BoolVar(&listenRefresh, "refresh", false, "Request a dump of all device states when starting")
+	listenCmd.Flags().StringSliceVar(&listenSinks, "sink", nil, "Also send events to these sinks, e.g. influx:states.lp or ndjson:events.json?max-size=10MB")
+	listenCmd.Flags().BoolVarP(&listenQuiet, "quiet", "q", false, "Do not print events")
 }
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool/mqttbridge"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mqttBroker          string
+	mqttUsername        string
+	mqttPassword        string
+	mqttClientID        string
+	mqttPrefix          string
+	mqttDiscoveryPrefix string
+)
+
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Bridge devices to an mqtt broker",
+	Long: `Publish device states to kizcool/<device>/<state> and run the commands received on kizcool/<device>/set.
+	Home Assistant discovery payloads are published for covers, lights and alarms, disable with --discovery-prefix "".
+	kizcmd mqtt --broker tcp://localhost:1883
+	mosquitto_pub -t kizcool/io_1111-0000-4444_11784413/set -m "setClosure 50"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := mqtt.NewClientOptions().
+			AddBroker(mqttBroker).
+			SetClientID(mqttClientID).
+			SetUsername(mqttUsername).
+			SetPassword(mqttPassword).
+			SetAutoReconnect(true).
+			SetWill(mqttPrefix+"/status", mqttbridge.Offline, 1, true)
+		client := mqtt.NewClient(opts)
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			log.Fatal(token.Error())
+		}
+		defer client.Disconnect(250)
+
+		bridge := mqttbridge.New(kiz, mqttbridge.NewPahoBroker(client), mqttbridge.WithLogger(logger{}))
+		bridge.Prefix = mqttPrefix
+		bridge.DiscoveryPrefix = mqttDiscoveryPrefix
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+		if err := bridge.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mqttCmd)
+	mqttCmd.Flags().StringVar(&mqttBroker, "broker", "tcp://localhost:1883", "URL of the mqtt broker")
+	mqttCmd.Flags().StringVar(&mqttUsername, "username", "", "Username for the mqtt broker")
+	mqttCmd.Flags().StringVar(&mqttPassword, "password", "", "Password for the mqtt broker")
+	mqttCmd.Flags().StringVar(&mqttClientID, "client-id", "kizcmd", "Client id for the mqtt broker")
+	mqttCmd.Flags().StringVar(&mqttPrefix, "prefix", mqttbridge.DefaultPrefix, "Prefix of the device topics")
+	mqttCmd.Flags().StringVar(&mqttDiscoveryPrefix, "discovery-prefix", mqttbridge.DefaultDiscoveryPrefix, "Home Assistant discovery prefix")
+}
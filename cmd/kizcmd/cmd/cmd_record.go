@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/history"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyDB     string
+	recordRefresh bool
+)
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record events and state changes in a database",
+	Long: `Continuously poll for events and record them, with the state changes they carry, in a SQLite database.
+	Query the database with the history command. SQLite needs kizcmd to be built with cgo.
+	kizcmd record --db events.db --refresh`,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := history.Open(historyDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		record := func(event kizcool.Event) {
+			if err := store.Record(event); err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("Error recording event")
+			}
+		}
+
+		// the first poll registers the listener, so that events caused by the refresh are not lost
+		initial, err := kiz.PollEvents()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, event := range initial {
+			record(event)
+		}
+		if recordRefresh {
+			if err := kiz.RefreshStates(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		events := make(chan kizcool.Event)
+		finish := make(chan struct{})
+		e := make(chan error)
+		defer close(finish)
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+
+		go kiz.PollEventsContinuous(events, e, finish)
+
+		for {
+			select {
+			case err := <-e:
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("Polling error, will resume after a pause.")
+			case event := <-events:
+				record(event)
+			case <-interrupt:
+				return
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(recordCmd)
+	recordCmd.Flags().StringVar(&historyDB, "db", "events.db", "Path of the SQLite database")
+	recordCmd.Flags().BoolVar(&recordRefresh, "refresh", false, "Request a dump of all device states when starting")
+}
@@ -1,42 +1,63 @@
 package cmd
 
 import (
+	"net/http"
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
 	"github.com/sgrimee/kizcool/config"
 	"github.com/spf13/cobra"
 )
 
 var kiz *kizcool.Kiz
 
+// set by command-line parameters
+var (
+	force      bool
+	dryRun     bool
+	recordFile string
+	replayFile string
+	debug      bool
+	logFormat  string
+)
+
+// recorder records the http interactions when --record is given
+var recorder *api.Recorder
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "kizcmd",
 	Short: "Overkiz command-line client",
 	Long:  `kizcmd implements a partial client for the Overkiz home automation api.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		if cmd.Name() == "configure" {
+			return
+		}
+		kiz = kizFromConfig()
+		if !force {
+			setGuard(kiz)
+		}
+		if dryRun {
+			kiz.SetDryRun(os.Stdout)
+		}
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-
-	if (len(os.Args) > 1) && (os.Args[1] != "configure") {
-		kiz = kizFromConfig()
-	}
-
-	RootCmd.PersistentFlags().BoolP("debug", "d", false, "enable debugging")
-	if config.Debug() {
-		log.SetLevel(log.DebugLevel)
-	}
-
+	log.RegisterExitHandler(saveRecording)
 	if err := RootCmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
+	saveRecording()
 
-	if config.UsingConfigFile() {
+	if kiz != nil && replayFile == "" && config.UsingConfigFile() {
 		id := kiz.SessionID()
 		config.SetSessionID(id)
 		if err := config.Write(); err != nil {
@@ -50,9 +71,88 @@ func kizFromConfig() *kizcool.Kiz {
 	if err := config.Read(false); err != nil {
 		log.Fatal(err)
 	}
-	k, err := kizcool.New(config.Username(), config.Password(), config.BaseURL(), config.SessionID())
+	if config.Debug() {
+		log.SetLevel(log.DebugLevel)
+	}
+	var k *kizcool.Kiz
+	var err error
+	if strings.HasPrefix(config.BaseURL(), simScheme) {
+		k, err = startSimulator(config.BaseURL()).NewKiz(api.WithLogger(logger{}))
+	} else {
+		k, err = kizWithTransport(transportFromFlags())
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	k.SetGroups(config.Groups())
 	return k
 }
+
+// kizWithTransport returns a kiz using the config credentials and the http transport
+func kizWithTransport(transport http.RoundTripper) (*kizcool.Kiz, error) {
+	return kizcool.NewClient(
+		kizcool.WithCredentials(config.Username(), config.Password()),
+		kizcool.WithBaseURL(config.BaseURL()),
+		kizcool.WithSessionStore(api.NewMemorySessionStore(config.SessionID())),
+		kizcool.WithHTTPClient(&http.Client{Transport: transport}),
+		kizcool.WithTimeout(api.DefaultTimeout),
+		kizcool.WithLogger(logger{}),
+	)
+}
+
+// transportFromFlags returns the http transport recording or replaying a cassette, or nil
+// for the default transport
+func transportFromFlags() http.RoundTripper {
+	if recordFile != "" && replayFile != "" {
+		log.Fatal("Cannot use --record and --replay together")
+	}
+	if replayFile != "" {
+		cassette, err := api.LoadCassette(replayFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return api.NewReplayer(cassette)
+	}
+	if recordFile != "" {
+		recorder = api.NewRecorder(nil)
+		return recorder
+	}
+	return nil
+}
+
+// saveRecording writes the recorded cassette, if any
+func saveRecording() {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Save(recordFile); err != nil {
+		log.Errorf("Unable to save the recording: %s", err)
+		return
+	}
+	recorder = nil
+}
+
+// setGuard makes kiz check the policies of the config file before executing commands
+func setGuard(k *kizcool.Kiz) {
+	var policies []kizcool.Policy
+	if err := config.UnmarshalKey("guards", &policies); err != nil {
+		log.Fatalf("Invalid guards in config file: %s", err)
+	}
+	if len(policies) == 0 {
+		return
+	}
+	guard, err := kizcool.NewGuard(policies, k, k.Groups())
+	if err != nil {
+		log.Fatal(err)
+	}
+	k.SetGuard(guard)
+}
+
+func init() {
+	RootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Enable debugging, including the http traffic with secrets redacted")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Format of the logs: text or json")
+	RootCmd.PersistentFlags().BoolVar(&force, "force", false, "Execute commands even if a guard policy refuses them")
+	RootCmd.PersistentFlags().StringVar(&recordFile, "record", "", "Record the http interactions with the server to a cassette file")
+	RootCmd.PersistentFlags().StringVar(&replayFile, "replay", "", "Replay the http interactions of a cassette file instead of contacting the server")
+	RootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Validate and print the commands that would be sent, without sending them")
+}
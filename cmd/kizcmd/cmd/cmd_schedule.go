@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool/schedule"
+	"github.com/spf13/cobra"
+)
+
+var scheduleCount int
+
+// scheduleCmd represents the schedule command
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run commands at scheduled times",
+	Long: `Run commands and scenarios at times given by cron expressions or solar events
+	(sunrise, sunset, civil dawn and dusk) computed locally, with optional random offsets.
+	See the schedule package documentation for the file format.`,
+}
+
+var scheduleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run a schedule until interrupted",
+	Long: `Run the jobs of a schedule file until interrupted.
+	kizcmd schedule run schedule.yaml --dry-run`,
+	Run: func(cmd *cobra.Command, args []string) {
+		s := loadSchedule(args)
+		s.DryRun = dryRun
+		for _, f := range s.Next(time.Now(), 1) {
+			log.Infof("First job %q at %v", f.Job, f.Time)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+		s.Run(ctx)
+	},
+}
+
+var scheduleNextCmd = &cobra.Command{
+	Use:   "next",
+	Short: "Print the upcoming firings of a schedule",
+	Long: `Print the upcoming firings of a schedule file, without random offsets.
+	kizcmd schedule next schedule.yaml -n 5`,
+	Run: func(cmd *cobra.Command, args []string) {
+		s := loadSchedule(args)
+		output(outputFormat, s.Next(time.Now(), scheduleCount))
+	},
+}
+
+// loadSchedule compiles the schedule file given as single argument
+func loadSchedule(args []string) *schedule.Scheduler {
+	if len(args) != 1 {
+		log.Fatal("You must specify a schedule file")
+	}
+	cfg, err := schedule.LoadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	setup, err := kiz.GetSetup()
+	if err != nil {
+		log.Fatal(err)
+	}
+	scenarios, err := kiz.GetActionGroups()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := schedule.New(cfg, setup, scenarios, kiz, schedule.WithLogger(logger{}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+func init() {
+	RootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.AddCommand(scheduleRunCmd)
+	scheduleCmd.AddCommand(scheduleNextCmd)
+	scheduleNextCmd.Flags().IntVarP(&scheduleCount, "count", "n", 10, "Number of firings to print")
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen string
+	serveAPIKey string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a local REST api",
+	Long: `Serve a local REST/JSON api sharing one session with the server, for tools that cannot use the library.
+	Device states are served from a cache updated with events, and events are streamed at /events.
+	The api key can also be given in the KIZ_API_KEY environment variable.
+	kizcmd serve --listen 127.0.0.1:8080 --api-key secret
+	curl -H "X-API-Key: secret" -X POST localhost:8080/devices/Fenetre1/commands/setClosure -d '[50]'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		srv, err := server.New(kiz, server.WithLogger(logger{}))
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.APIKey = serveAPIKey
+		if srv.APIKey == "" {
+			srv.APIKey = os.Getenv("KIZ_API_KEY")
+		}
+		if srv.APIKey == "" {
+			log.Warn("No api key, any local client can control the devices")
+		}
+		httpServer := &http.Server{Addr: serveListen, Handler: srv}
+		go func() {
+			if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		log.Infof("Serving on %s", serveListen)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+		srv.Run(ctx)
+		httpServer.Close()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveAPIKey, "api-key", "", "Key required from clients in the X-API-Key header")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool/config"
+	"github.com/sgrimee/kizcool/kiztest"
+	"github.com/spf13/cobra"
+)
+
+// simScheme is the prefix of base urls running the commands against an in-process simulation
+const simScheme = "sim://"
+
+// defaultTravel is the time taken by simulated shutters to fully open or close
+const defaultTravel = 20 * time.Second
+
+var (
+	simFixture string
+	simListen  string
+	simTravel  time.Duration
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Serve a simulated installation",
+	Long: `Serve the Overkiz api for a simulated installation with the devices of a fixture file, until interrupted.
+	The fixture is a list of devices or a setup in json, e.g. the output of 'kizcmd get devices -o json'.
+	Commands change the device states and emit events, shutters move over the travel time.
+	Set base_url to the printed url to run other commands against the simulation, with the same credentials.
+	To run a single command against an in-process simulation, set base_url to sim://<fixture>.
+	kizcmd simulate --fixture devices.json --listen 127.0.0.1:8765`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if simFixture == "" {
+			log.Fatal("You must specify a fixture file")
+		}
+		s := newSimulator(simFixture, simTravel)
+		l, err := net.Listen("tcp", simListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Listener.Close()
+		s.Listener = l
+		s.Start()
+		defer s.Close()
+		log.Infof("Simulating %d devices on %s", len(s.Devices()), s.URL)
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+	},
+}
+
+// newSimulator returns an unstarted simulation of the devices of the fixture,
+// accepting the credentials of the config
+func newSimulator(fixture string, travel time.Duration) *kiztest.Server {
+	s := kiztest.NewUnstartedServer()
+	if err := s.LoadFile(fixture); err != nil {
+		log.Fatal(err)
+	}
+	s.SetCredentials(config.Username(), config.Password())
+	s.SetMotion(travel, travel/20)
+	return s
+}
+
+// startSimulator starts an in-process simulation, for base urls starting with sim://
+func startSimulator(baseURL string) *kiztest.Server {
+	fixture := strings.TrimPrefix(baseURL, simScheme)
+	if fixture == "" {
+		log.Fatalf("The base url %s needs a fixture file, e.g. %sdevices.json", baseURL, simScheme)
+	}
+	s := newSimulator(fixture, defaultTravel)
+	s.Start()
+	return s
+}
+
+func init() {
+	RootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringVar(&simFixture, "fixture", "", "Json file with the devices or setup to simulate")
+	simulateCmd.Flags().StringVar(&simListen, "listen", "127.0.0.1:8765", "Address to serve the api on")
+	simulateCmd.Flags().DurationVar(&simTravel, "travel", defaultTravel, "Time for shutters to fully open or close, 0 to move at once")
+}
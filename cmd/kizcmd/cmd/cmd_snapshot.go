@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	homedir "github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotFile    string
+	snapshotDevices string
+)
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and restore device states",
+	Long: `Save the closure, intensity and on/off states of devices under a name, and restore them later
+	in a single execution. Snapshots are stored in a json file.`,
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save",
+	Short: "Save the current states of devices",
+	Long: `Save the current states of the devices under the given name, replacing any previous snapshot with that name.
+	kizcmd snapshot save evening --devices "class=RollerShutter"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("You must specify a snapshot name")
+		}
+		snapshot, err := kiz.Snapshot(devicesFromText(snapshotDevices))
+		if err != nil {
+			log.Fatal(err)
+		}
+		snapshot.Name = args[0]
+		snapshots := readSnapshots()
+		snapshots[snapshot.Name] = snapshot
+		writeSnapshots(snapshots)
+		log.Infof("Saved %d devices in snapshot %s", len(snapshot.Devices), snapshot.Name)
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the states of a snapshot",
+	Long: `Restore the states of the devices saved in the named snapshot, in a single execution.
+	kizcmd snapshot restore evening`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("You must specify a snapshot name")
+		}
+		snapshot, ok := readSnapshots()[args[0]]
+		if !ok {
+			log.Fatalf("No snapshot named %s", args[0])
+		}
+		if _, err := kiz.Restore(snapshot); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the saved snapshots",
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshots := readSnapshots()
+		var list []kizcool.Snapshot
+		for _, s := range snapshots {
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		output(outputFormat, list)
+	},
+}
+
+// readSnapshots returns the snapshots of the snapshot file by name
+func readSnapshots() map[string]kizcool.Snapshot {
+	snapshots := make(map[string]kizcool.Snapshot)
+	data, err := ioutil.ReadFile(snapshotPath())
+	if os.IsNotExist(err) {
+		return snapshots
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		log.Fatalf("Unable to read snapshots: %s", err)
+	}
+	return snapshots
+}
+
+// writeSnapshots saves the snapshots to the snapshot file
+func writeSnapshots(snapshots map[string]kizcool.Snapshot) {
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(snapshotPath(), data, 0600); err != nil {
+		log.Fatalf("Unable to save snapshots: %s", err)
+	}
+}
+
+// snapshotPath returns the path of the snapshot file, with ~ expanded
+func snapshotPath() string {
+	path, err := homedir.Expand(snapshotFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return path
+}
+
+func init() {
+	RootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.PersistentFlags().StringVar(&snapshotFile, "file", "~/.kizcool-snapshots.json", "File storing the snapshots")
+	snapshotSaveCmd.Flags().StringVar(&snapshotDevices, "devices", "all", "Url, label or selector of the devices to save")
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/spf13/cobra"
+)
+
+var waitTimeout time.Duration
+
+// waitCmd represents the wait command
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait until a device state has a given value",
+	Long: `Wait until a device state has a given value. Exits with an error on timeout.
+	The arguments are the device url or label, the state name, an operator (=, !=, <, <=, >, >=) and a value.
+	kizcmd wait "my window" core:OpenClosedState = open --timeout 2m`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 4 {
+			log.Fatal("You must specify a device, state, operator and value")
+		}
+		dev := deviceFromText(args[0])
+		predicate, err := kizcool.StateCompare(args[2], args[3])
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+		state, err := kiz.WaitForState(ctx, dev, kizcool.StateName(args[1]), predicate)
+		if err == context.DeadlineExceeded {
+			log.Fatalf("Timeout waiting for %s %s %s, last value: %v", args[1], args[2], args[3], state.Value)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(waitCmd)
+	waitCmd.Flags().DurationVar(&waitTimeout, "timeout", 2*time.Minute, "Maximum time to wait")
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// devicesFromText returns the devices designated by a device url, label or selector
+// When the label is ambiguous and the session is interactive, the user is asked to pick one.
+func devicesFromText(text string) []kizcool.Device {
+	devices, err := kiz.GetDevicesByText(text)
+	if labelErr, ok := err.(*kizcool.LabelError); ok {
+		if labelErr.Ambiguous && interactive() {
+			return []kizcool.Device{pickDevice(labelErr.Candidates)}
+		}
+		log.Fatal(labelErrorMessage(labelErr))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return devices
+}
+
+// deviceFromText returns the single device designated by a device url, label or selector
+func deviceFromText(text string) kizcool.Device {
+	devices := devicesFromText(text)
+	if len(devices) != 1 {
+		log.Fatalf("%d devices match %q, a single one is expected", len(devices), text)
+	}
+	return devices[0]
+}
+
+// execute sends the command to all the devices designated by text, in a single execution
+func execute(text string, command kizcool.Command) {
+	ag, err := kizcool.ActionGroupWithCommand(devicesFromText(text), command)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := kiz.Execute(ag); err != nil {
+		if _, ok := err.(*kizcool.GuardError); ok {
+			log.Fatalf("%s, use --force to override", err)
+		}
+		log.Fatal(err)
+	}
+}
+
+// labelErrorMessage explains a label error, with suggestions if any
+func labelErrorMessage(e *kizcool.LabelError) string {
+	if len(e.Candidates) == 0 {
+		return e.Error()
+	}
+	var labels []string
+	for _, d := range e.Candidates {
+		labels = append(labels, fmt.Sprintf("'%s'", d.Label))
+	}
+	if e.Ambiguous {
+		return fmt.Sprintf("%s: %s", e.Error(), strings.Join(labels, ", "))
+	}
+	return fmt.Sprintf("%s, did you mean %s?", e.Error(), strings.Join(labels, " or "))
+}
+
+// interactive returns true if the user can answer questions on the terminal
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// pickDevice asks the user to choose one of the devices
+func pickDevice(devices []kizcool.Device) kizcool.Device {
+	fmt.Fprintln(os.Stderr, "More than one device with that label:")
+	for i, d := range devices {
+		fmt.Fprintf(os.Stderr, "%d) %s (%s, %s)\n", i+1, d.Label, d.UIClass, d.DeviceURL)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "Pick a device [1-%d]: ", len(devices))
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(answer)); err == nil && n >= 1 && n <= len(devices) {
+			return devices[n-1]
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logger passes the messages of the kizcool packages to logrus, keys and values becoming fields
+type logger struct{}
+
+func (logger) Debug(msg string, args ...interface{}) { entry(args).Debug(msg) }
+func (logger) Info(msg string, args ...interface{})  { entry(args).Info(msg) }
+func (logger) Warn(msg string, args ...interface{})  { entry(args).Warn(msg) }
+func (logger) Error(msg string, args ...interface{}) { entry(args).Error(msg) }
+
+// DebugEnabled tells whether logrus logs debug messages, so that http bodies are only read when needed
+func (logger) DebugEnabled() bool { return log.IsLevelEnabled(log.DebugLevel) }
+
+// entry returns a logrus entry with the alternating keys and values as fields
+func entry(args []interface{}) *log.Entry {
+	fields := make(log.Fields, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[fmt.Sprint(args[i])] = args[i+1]
+	}
+	if len(args)%2 == 1 {
+		fields["!BADKEY"] = args[len(args)-1]
+	}
+	return log.WithFields(fields)
+}
+
+// setupLogging applies the --debug and --log-format flags
+func setupLogging() {
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	switch logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("Unknown log format %s, use text or json", logFormat)
+	}
+}
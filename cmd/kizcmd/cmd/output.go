@@ -7,9 +7,15 @@ import (
 	"github.com/sgrimee/kizcool"
 )
 
+var outputFormat string // set by command-line parameter
+
 // output prints the object in the given format to stdout
 func output(format string, obj interface{}) {
 	if err := kizcool.Output(os.Stdout, format, obj); err != nil {
 		log.Fatal(err)
 	}
 }
+
+func init() {
+	RootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "Output format: text, json, yaml, table[=columns], wide, csv[=columns], template=<template> or jsonpath=<path>")
+}
@@ -0,0 +1,193 @@
+package kizcool
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// defaultColumns are the columns printed by the table and csv formats for each type.
+// The wide format adds wideColumns.
+var defaultColumns = map[reflect.Type][]string{
+	reflect.TypeOf(Device{}):      {"Label", "UIClass", "DeviceURL", "Available"},
+	reflect.TypeOf(DeviceState{}): {"Name", "Value"},
+	reflect.TypeOf(ActionGroup{}): {"Label", "OID", "Actions"},
+	reflect.TypeOf(Execution{}):   {"ID", "ActionGroup.Label", "State", "StartTime", "Owner"},
+	reflect.TypeOf(Setup{}):       {"ID", "Location.City", "Location.Timezone", "Gateways", "Devices"},
+	reflect.TypeOf(Place{}):       {"Label", "OID", "SubPlaces"},
+	reflect.TypeOf(StateChange{}): {"Time", "DeviceURL", "Name", "Value"},
+	reflect.TypeOf(Snapshot{}):    {"Name", "Time", "Devices"},
+	reflect.TypeOf(""):            {"Value"},
+}
+
+var wideColumns = map[reflect.Type][]string{
+	reflect.TypeOf(Device{}):      {"ControllableName", "PlaceOID", "Widget", "States"},
+	reflect.TypeOf(DeviceState{}): {"Type"},
+	reflect.TypeOf(ActionGroup{}): {"Shortcut", "CreationTime", "Commands"},
+	reflect.TypeOf(Execution{}):   {"ExecutionType", "ExecutionSubType", "Description"},
+	reflect.TypeOf(Setup{}):       {"OID", "Location.Latitude", "Location.Longitude"},
+	reflect.TypeOf(StateChange{}): {"Type"},
+}
+
+// eventColumns are the default columns of events, whatever their type
+var eventColumns = []string{"Time", "Name", "Device", "Summary"}
+
+var eventWideColumns = []string{"ExecID", "SetupOID"}
+
+// columnsFor returns the default columns for the item
+func columnsFor(item interface{}, wide bool) []string {
+	var cols, extra []string
+	if _, ok := item.(Event); ok {
+		cols, extra = eventColumns, eventWideColumns
+	} else {
+		t := reflect.TypeOf(item)
+		cols, extra = defaultColumns[t], wideColumns[t]
+	}
+	if cols == nil {
+		cols = structColumns(item)
+	}
+	if wide {
+		return append(append([]string{}, cols...), extra...)
+	}
+	return cols
+}
+
+// structColumns returns the exported fields of a struct item, or Value for other items
+func structColumns(item interface{}) []string {
+	t := reflect.TypeOf(item)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return []string{"Value"}
+	}
+	var cols []string
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			cols = append(cols, f.Name)
+		}
+	}
+	return cols
+}
+
+// columnValue returns the text value of the named column for the item.
+// Columns are field names, possibly nested with dots (e.g. Definition.UIClass) and case insensitive.
+// Devices also accept state names (e.g. core:ClosureState) and events the Time, Device
+// and Summary columns. Unknown columns are empty.
+func columnValue(item interface{}, column string) string {
+	switch t := item.(type) {
+	case Device:
+		if strings.Contains(column, ":") {
+			for _, s := range t.States {
+				if string(s.Name) == column {
+					return fmt.Sprint(s.Value)
+				}
+			}
+			return ""
+		}
+		if strings.EqualFold(column, "States") {
+			return stateSummary(t.States)
+		}
+	case ActionGroup:
+		if strings.EqualFold(column, "Commands") {
+			var commands []string
+			for _, a := range t.Actions {
+				for _, c := range a.Commands {
+					commands = append(commands, c.Name)
+				}
+			}
+			return strings.Join(commands, ",")
+		}
+	case Event:
+		switch strings.ToLower(column) {
+		case "time":
+			return EventTime(t).Format(time.RFC3339)
+		case "device":
+			var urls []string
+			for _, url := range EventDeviceURLs(t) {
+				urls = append(urls, string(url))
+			}
+			return strings.Join(urls, ",")
+		case "summary":
+			return eventSummary(t)
+		}
+	case string:
+		if strings.EqualFold(column, "Value") {
+			return t
+		}
+	}
+	v := reflect.ValueOf(item)
+	for _, name := range strings.Split(column, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return ""
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return ""
+		}
+		v = v.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+		if !v.IsValid() {
+			return ""
+		}
+	}
+	return formatValue(v)
+}
+
+// formatValue prints a field value, lists are summarized by their length
+func formatValue(v reflect.Value) string {
+	if v.Type() == reflect.TypeOf(time.Time{}) && v.CanInterface() {
+		return v.Interface().(time.Time).Format(time.RFC3339)
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprint(v.Len())
+	case reflect.Struct:
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// stateSummary prints the states most useful to a human
+func stateSummary(states []DeviceState) string {
+	var parts []string
+	for _, s := range states {
+		if wantedStates[s.Name] {
+			parts = append(parts, fmt.Sprintf("%s=%v", s.Name, s.Value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// eventSummary prints the most useful details of an event
+func eventSummary(e Event) string {
+	switch t := e.(type) {
+	case *DeviceStateChangedEvent:
+		var parts []string
+		for _, s := range t.DeviceStates {
+			parts = append(parts, fmt.Sprintf("%s=%v", s.Name, s.Value))
+		}
+		return strings.Join(parts, " ")
+	case *CommandExecutionStateChangedEvent:
+		return fmt.Sprintf("%s %s", t.ExecID, t.NewState)
+	case *ExecutionRegisteredEvent:
+		return fmt.Sprintf("%s %q", t.ExecID, t.Label)
+	case *ExecutionStateChangedEvent:
+		return fmt.Sprintf("%s %s->%s", t.ExecID, t.OldState, t.NewState)
+	case *EndUserLoginEvent:
+		return t.UserID
+	case *GatewayDownEvent:
+		return t.GatewayID
+	case *GatewayAliveEvent:
+		return t.GatewayID
+	case *GatewaySynchronizationStartedEvent:
+		return t.GatewayID
+	case *GatewaySynchronizationEndedEvent:
+		return t.GatewayID
+	case *RefreshAllDevicesStatesCompletedEvent:
+		return t.GatewayID
+	}
+	return ""
+}
@@ -55,6 +55,17 @@ func SetSessionID(ID string) {
 	viper.Set("session_id", ID)
 }
 
+// Groups returns the device groups by name. Names are lower case.
+func Groups() map[string][]string {
+	return viper.GetStringMapStringSlice("groups")
+}
+
+// UnmarshalKey decodes a structured config value, e.g. the guard policies, into v.
+// Decoding is left to the caller so that this package does not import kizcool.
+func UnmarshalKey(key string, v interface{}) error {
+	return viper.UnmarshalKey(key, v)
+}
+
 // Read reads in config file. It should be called before using other functions in this package.
 // The local directory is searched first, then the user's home directory
 // If no file is found and create is true, a config file with defaults is created.
@@ -3,8 +3,7 @@ package kizcool
 import (
 	"encoding/json"
 	"fmt"
-
-	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 // Event is an interface for any event
@@ -23,6 +22,48 @@ func (e *GenericEvent) Kind() string {
 	return fmt.Sprintf("%v", e)
 }
 
+// generic gives access to the shared fields of any event embedding GenericEvent
+func (e *GenericEvent) generic() *GenericEvent {
+	return e
+}
+
+// EventName returns the name of the event, e.g. "DeviceStateChangedEvent"
+func EventName(e Event) string {
+	if g, ok := e.(interface{ generic() *GenericEvent }); ok {
+		return g.generic().Name
+	}
+	return ""
+}
+
+// EventTime returns the time at which the event was emitted by the server
+func EventTime(e Event) time.Time {
+	if g, ok := e.(interface{ generic() *GenericEvent }); ok && g.generic().Timestamp != 0 {
+		return time.Unix(0, int64(g.generic().Timestamp)*int64(time.Millisecond))
+	}
+	return time.Time{}
+}
+
+// EventDeviceURLs returns the URLs of the devices the event relates to, if any
+func EventDeviceURLs(e Event) []DeviceURL {
+	switch t := e.(type) {
+	case *DeviceStateChangedEvent:
+		return []DeviceURL{t.DeviceURL}
+	case *CommandExecutionStateChangedEvent:
+		return []DeviceURL{t.DeviceURL}
+	case *DeviceAvailableEvent:
+		return []DeviceURL{t.DeviceURL}
+	case *DeviceUnavailableEvent:
+		return []DeviceURL{t.DeviceURL}
+	case *ExecutionRegisteredEvent:
+		var urls []DeviceURL
+		for _, a := range t.Actions {
+			urls = append(urls, a.DeviceURL)
+		}
+		return urls
+	}
+	return nil
+}
+
 // ExecutionEvent is the minimal set of fields shared by all Execution events
 type ExecutionEvent struct {
 	ExecID   ExecID `json:"execID,omitempty"`
@@ -107,6 +148,25 @@ type DeviceStateChangedEvent struct {
 	DeviceStates []DeviceState `json:"deviceStates,omitempty"`
 }
 
+// DeviceAvailabilityEvent is the set of fields shared by device availability events
+type DeviceAvailabilityEvent struct {
+	SetupOID     string    `json:"setupOID,omitempty"`
+	DeviceURL    DeviceURL `json:"deviceURL,omitempty"`
+	ProtocolType int       `json:"protocolType,omitempty"`
+}
+
+// DeviceAvailableEvent indicates a device can be reached again
+type DeviceAvailableEvent struct {
+	GenericEvent
+	DeviceAvailabilityEvent
+}
+
+// DeviceUnavailableEvent indicates a device cannot be reached, e.g. when its battery is empty
+type DeviceUnavailableEvent struct {
+	GenericEvent
+	DeviceAvailabilityEvent
+}
+
 // EndUserLoginEvent happens when a user authenticates
 type EndUserLoginEvent struct {
 	GenericEvent
@@ -124,10 +184,6 @@ func (events *Events) UnmarshalJSON(data []byte) error {
 	var raw []json.RawMessage
 	err := json.Unmarshal(data, &raw)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"data": data,
-			"err":  err,
-		}).Debug("Error splitting into raw items")
 		return fmt.Errorf("Error splitting json into raw items. %w", err)
 	}
 	for _, r := range raw {
@@ -135,10 +191,6 @@ func (events *Events) UnmarshalJSON(data []byte) error {
 		var obj map[string]interface{}
 		err := json.Unmarshal(r, &obj)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"err":  err,
-				"data": r,
-			}).Debug("Error retrieving Name field")
 			return fmt.Errorf("Error retrieving Name field. %w", err)
 		}
 
@@ -168,6 +220,10 @@ func (events *Events) UnmarshalJSON(data []byte) error {
 			actual = &RefreshAllDevicesStatesCompletedEvent{}
 		case "DeviceStateChangedEvent":
 			actual = &DeviceStateChangedEvent{}
+		case "DeviceAvailableEvent":
+			actual = &DeviceAvailableEvent{}
+		case "DeviceUnavailableEvent":
+			actual = &DeviceUnavailableEvent{}
 		case "EndUserLoginEvent":
 			actual = &EndUserLoginEvent{}
 		default:
@@ -176,13 +232,15 @@ func (events *Events) UnmarshalJSON(data []byte) error {
 
 		err = json.Unmarshal(r, actual)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"err":  err,
-				"data": r,
-			}).Debug("Error unmarshalling into struct")
 			return fmt.Errorf("Error unmarshalling into struct. %w", err)
 		}
 		*events = append(*events, actual)
 	}
 	return nil
 }
+
+// Sink receives events, e.g. to store them. See the sink package for implementations.
+type Sink interface {
+	Record(Event) error
+	Close() error
+}
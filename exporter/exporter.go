@@ -0,0 +1,227 @@
+// Package exporter exposes the states of devices as prometheus metrics.
+//
+// Device states are read from the setup at startup then kept up to date with events.
+// Metrics are written in the prometheus text exposition format:
+//
+//	kizcool_state{device,label,state}                numeric states
+//	kizcool_state_info{device,label,state,value}     discrete states, always 1
+//	kizcool_device_available{device,label}           1 if the device is reachable
+//	kizcool_gateway_up{gateway}                      1 if the gateway is alive
+//	kizcool_events_total{name}                       events received
+//	kizcool_api_calls_total                          requests sent to the api server
+//	kizcool_api_logins_total                         logins to the api server
+//	kizcool_api_errors_total{type}                   api errors by type
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Exporter keeps a cache of device states and serves them as metrics over http
+type Exporter struct {
+	kiz    kizcool.Client
+	cache  *kizcool.StateCache
+	logger api.Logger
+
+	mux    sync.Mutex
+	events map[string]int
+}
+
+// Option configures an Exporter, see New
+type Option func(*Exporter)
+
+// WithLogger makes the exporter log polling and writing errors to l.
+func WithLogger(l api.Logger) Option {
+	return func(e *Exporter) {
+		if l == nil {
+			l = api.NopLogger{}
+		}
+		e.logger = l
+	}
+}
+
+// New returns an exporter initialized with the current setup
+func New(kiz kizcool.Client, opts ...Option) (*Exporter, error) {
+	setup, err := kiz.GetSetup()
+	if err != nil {
+		return nil, err
+	}
+	return NewWithCache(kiz, kizcool.NewStateCache(setup), opts...), nil
+}
+
+// NewWithCache returns an exporter using an existing state cache
+func NewWithCache(kiz kizcool.Client, cache *kizcool.StateCache, opts ...Option) *Exporter {
+	e := &Exporter{
+		kiz:    kiz,
+		cache:  cache,
+		logger: api.NopLogger{},
+		events: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Cache returns the state cache of the exporter
+func (e *Exporter) Cache() *kizcool.StateCache {
+	return e.cache
+}
+
+// Update applies an event to the state cache and counts it
+func (e *Exporter) Update(event kizcool.Event) {
+	e.cache.Update(event)
+	e.mux.Lock()
+	e.events[kizcool.EventName(event)]++
+	e.mux.Unlock()
+}
+
+// Run polls for events and updates the cache until the context is done.
+// Polling errors are logged and polling resumes after a pause.
+func (e *Exporter) Run(ctx context.Context) error {
+	for event := range kizcool.ListenEvents(ctx, e.kiz, e.logger) {
+		e.Update(event)
+	}
+	return ctx.Err()
+}
+
+// ServeHTTP writes the metrics
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := e.WriteMetrics(w); err != nil {
+		e.logger.Error("Error writing metrics", "err", err)
+	}
+}
+
+// WriteMetrics writes all metrics in the prometheus text format
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	m := &metricWriter{w: w}
+	devices := e.cache.Devices()
+
+	m.header("kizcool_state", "gauge", "Numeric state of a device.")
+	for _, d := range devices {
+		for _, s := range d.States {
+			if v, ok := numericValue(s); ok {
+				m.sample("kizcool_state", v, "device", string(d.DeviceURL), "label", d.Label, "state", string(s.Name))
+			}
+		}
+	}
+
+	m.header("kizcool_state_info", "gauge", "Discrete state of a device, the value is in the value label.")
+	for _, d := range devices {
+		for _, s := range d.States {
+			if value, ok := s.Value.(string); ok && s.Type == kizcool.StateString {
+				m.sample("kizcool_state_info", 1, "device", string(d.DeviceURL), "label", d.Label, "state", string(s.Name), "value", value)
+			}
+		}
+	}
+
+	m.header("kizcool_device_available", "gauge", "Whether the device can be reached.")
+	for _, d := range devices {
+		m.sample("kizcool_device_available", boolValue(d.Available), "device", string(d.DeviceURL), "label", d.Label)
+	}
+
+	m.header("kizcool_gateway_up", "gauge", "Whether the gateway is alive.")
+	gateways := e.cache.Gateways()
+	var ids []string
+	for id := range gateways {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		m.sample("kizcool_gateway_up", boolValue(gateways[id]), "gateway", id)
+	}
+
+	m.header("kizcool_events_total", "counter", "Events received from the server, by name.")
+	e.mux.Lock()
+	events := make(map[string]int, len(e.events))
+	for name, n := range e.events {
+		events[name] = n
+	}
+	e.mux.Unlock()
+	for _, name := range sortedKeys(events) {
+		m.sample("kizcool_events_total", float64(events[name]), "name", name)
+	}
+
+	var stats api.Stats
+	if e.kiz != nil {
+		stats = e.kiz.Stats()
+	}
+	m.header("kizcool_api_calls_total", "counter", "Requests sent to the api server.")
+	m.sample("kizcool_api_calls_total", float64(stats.Calls))
+	m.header("kizcool_api_logins_total", "counter", "Logins to the api server.")
+	m.sample("kizcool_api_logins_total", float64(stats.Logins))
+	m.header("kizcool_api_errors_total", "counter", "Errors returned by the api server, by type.")
+	for _, t := range sortedKeys(stats.Errors) {
+		m.sample("kizcool_api_errors_total", float64(stats.Errors[t]), "type", t)
+	}
+	return m.err
+}
+
+// numericValue returns the value of int and float states, and of boolean states as 0 or 1
+func numericValue(s kizcool.DeviceState) (float64, bool) {
+	if b, ok := s.Value.(bool); ok {
+		return boolValue(b), true
+	}
+	if s.Type != kizcool.StateInt && s.Type != kizcool.StateFloat {
+		return 0, false
+	}
+	return s.Float()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricWriter writes metrics in the text format, keeping the first error
+type metricWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (m *metricWriter) printf(format string, a ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, a...)
+	}
+}
+
+func (m *metricWriter) header(name, kind, help string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value, labels are given as name, value pairs
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.printf("%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// labelEscaper escapes label values as required by the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestExporter(t *testing.T) (*Exporter, *httptest.Server) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "getSetup.json"))
+	assert.NoError(t, err)
+	var fixture struct {
+		Setup json.RawMessage
+	}
+	assert.NoError(t, json.Unmarshal(data, &fixture))
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/setup", req.URL.String())
+		rw.Write(fixture.Setup)
+	}))
+	ac, err := api.NewWithHTTPClient("", "", server.URL, "", server.Client())
+	assert.NoError(t, err)
+	kiz, _ := kizcool.NewWithAPIClient(ac)
+	exp, err := New(kiz)
+	assert.NoError(t, err)
+	return exp, server
+}
+
+func TestWriteMetrics(t *testing.T) {
+	exp, server := getTestExporter(t)
+	defer server.Close()
+	const url = "io://1111-0000-4444/11111111"
+	exp.Update(&kizcool.DeviceStateChangedEvent{
+		GenericEvent: kizcool.GenericEvent{Name: "DeviceStateChangedEvent"},
+		DeviceURL:    url,
+		DeviceStates: []kizcool.DeviceState{{Name: "core:ClosureState", Type: kizcool.StateInt, Value: 30}},
+	})
+	exp.Update(&kizcool.GatewayDownEvent{
+		GenericEvent: kizcool.GenericEvent{Name: "GatewayDownEvent"},
+		GatewayEvent: kizcool.GatewayEvent{GatewayID: "1111-0000-4444"},
+	})
+
+	rec := httptest.NewRecorder()
+	exp.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE kizcool_state gauge\n",
+		`kizcool_state{device="io://1111-0000-4444/11111111",label="Fenetre1",state="core:ClosureState"} 30` + "\n",
+		`kizcool_state{device="io://1111-0000-4444/11111111",label="Fenetre1",state="core:RSSILevelState"} 100` + "\n",
+		`kizcool_state_info{device="io://1111-0000-4444/11111111",label="Fenetre1",state="core:OpenClosedState",value="closed"} 1` + "\n",
+		`kizcool_device_available{device="io://1111-0000-4444/11111111",label="Fenetre1"} 1` + "\n",
+		`kizcool_gateway_up{gateway="1111-0000-4444"} 0` + "\n",
+		`kizcool_events_total{name="DeviceStateChangedEvent"} 1` + "\n",
+		"kizcool_api_calls_total 1\n",
+		"kizcool_api_logins_total 0\n",
+	} {
+		assert.Contains(t, metrics, want)
+	}
+	assert.NotContains(t, metrics, `state="core:OpenClosedState"} `)
+}
+
+func TestLabelEscaper(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, labelEscaper.Replace("a\"b\\c\nd"))
+}
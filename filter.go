@@ -0,0 +1,76 @@
+package kizcool
+
+// EventFilter selects events by device, event name and state name.
+// An empty list matches everything for that criterion.
+type EventFilter struct {
+	DeviceURLs []DeviceURL
+	Names      []string
+	States     []StateName
+}
+
+// Filter returns the event if it matches the filter, or false if it does not.
+// When States are given, only DeviceStateChangedEvents can match and the
+// returned event only contains the wanted states.
+func (f EventFilter) Filter(e Event) (Event, bool) {
+	if len(f.Names) > 0 && !containsString(f.Names, EventName(e)) {
+		return nil, false
+	}
+	if len(f.DeviceURLs) > 0 {
+		found := false
+		for _, url := range EventDeviceURLs(e) {
+			if containsDeviceURL(f.DeviceURLs, url) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	if len(f.States) > 0 {
+		dsce, ok := e.(*DeviceStateChangedEvent)
+		if !ok {
+			return nil, false
+		}
+		var states []DeviceState
+		for _, s := range dsce.DeviceStates {
+			if containsStateName(f.States, s.Name) {
+				states = append(states, s)
+			}
+		}
+		if len(states) == 0 {
+			return nil, false
+		}
+		filtered := *dsce
+		filtered.DeviceStates = states
+		return &filtered, true
+	}
+	return e, true
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDeviceURL(list []DeviceURL, url DeviceURL) bool {
+	for _, l := range list {
+		if l == url {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStateName(list []StateName, name StateName) bool {
+	for _, l := range list {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package kizcool
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GroupPrefix designates a group in a device text, e.g. @upstairs
+const GroupPrefix = "@"
+
+// Groups are named lists of devices. Members are device urls, labels or selectors,
+// or other groups prefixed with @. Group names are case insensitive.
+type Groups map[string][]string
+
+// Group is a named list of devices, see Groups
+type Group struct {
+	Name    string
+	Members []string
+}
+
+// List returns the groups sorted by name
+func (g Groups) List() []Group {
+	var list []Group
+	for name, members := range g {
+		list = append(list, Group{Name: name, Members: members})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// members returns the members of the named group
+func (g Groups) members(name string) ([]string, bool) {
+	if members, ok := g[name]; ok {
+		return members, true
+	}
+	for n, members := range g {
+		if strings.EqualFold(n, name) {
+			return members, true
+		}
+	}
+	return nil, false
+}
+
+// Devices returns the devices of the setup in the named group and its nested groups, without duplicates.
+// The name may start with @.
+func (g Groups) Devices(name string, setup Setup) ([]Device, error) {
+	var devices []Device
+	seen := make(map[DeviceURL]bool)
+	err := g.resolve(strings.TrimPrefix(name, GroupPrefix), setup, nil, func(d Device) {
+		if !seen[d.DeviceURL] {
+			seen[d.DeviceURL] = true
+			devices = append(devices, d)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// resolve calls add for each device of the group, path is the chain of enclosing groups
+func (g Groups) resolve(name string, setup Setup, path []string, add func(Device)) error {
+	for _, p := range path {
+		if strings.EqualFold(p, name) {
+			return cycleError(name, path)
+		}
+	}
+	members, ok := g.members(name)
+	if !ok {
+		return fmt.Errorf("No group named %s", name)
+	}
+	path = append(path, name)
+	for _, m := range members {
+		if strings.HasPrefix(m, GroupPrefix) {
+			if err := g.resolve(strings.TrimPrefix(m, GroupPrefix), setup, path, add); err != nil {
+				return err
+			}
+			continue
+		}
+		devices, err := DevicesFromSetupByText(m, setup)
+		if err != nil {
+			return fmt.Errorf("Group %s, member %q: %w", name, m, err)
+		}
+		for _, d := range devices {
+			add(d)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the members of all the groups exist in the setup and that no group contains itself
+func (g Groups) Validate(setup Setup) error {
+	var problems []string
+	for _, group := range g.List() {
+		for _, m := range group.Members {
+			if strings.HasPrefix(m, GroupPrefix) {
+				if _, ok := g.members(strings.TrimPrefix(m, GroupPrefix)); !ok {
+					problems = append(problems, fmt.Sprintf("Group %s, member %s: no such group", group.Name, m))
+				}
+			} else if _, err := DevicesFromSetupByText(m, setup); err != nil {
+				problems = append(problems, fmt.Sprintf("Group %s, member %q: %s", group.Name, m, err))
+			}
+		}
+		if err := g.checkCycle(group.Name, nil); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid groups: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkCycle returns an error if the named group contains itself, path is the chain of enclosing groups
+func (g Groups) checkCycle(name string, path []string) error {
+	for _, p := range path {
+		if strings.EqualFold(p, name) {
+			return cycleError(name, path)
+		}
+	}
+	members, _ := g.members(name)
+	path = append(path, name)
+	for _, m := range members {
+		if strings.HasPrefix(m, GroupPrefix) {
+			if err := g.checkCycle(strings.TrimPrefix(m, GroupPrefix), path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func cycleError(name string, path []string) error {
+	return fmt.Errorf("Group %s contains itself: %s", name, strings.Join(append(path, name), " > "))
+}
@@ -0,0 +1,205 @@
+package kizcool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy refuses commands sent to some devices, when a state condition holds or when the
+// parameter of the command is out of range.
+type Policy struct {
+	Name string `yaml:"name" mapstructure:"name"`
+	// Devices is the url, label, selector or @group of the guarded devices, all devices if empty
+	Devices string `yaml:"devices" mapstructure:"devices"`
+	// Commands are the guarded commands, all commands if empty
+	Commands []string `yaml:"commands" mapstructure:"commands"`
+	// RefuseWhen refuses the commands while the condition holds
+	RefuseWhen *PolicyCondition `yaml:"refuse_when" mapstructure:"refuse_when"`
+	// Min and Max is the allowed range of the first parameter of the commands
+	Min *float64 `yaml:"min" mapstructure:"min"`
+	Max *float64 `yaml:"max" mapstructure:"max"`
+}
+
+// PolicyCondition holds when the state of any of the devices compares to the value
+type PolicyCondition struct {
+	Device string `yaml:"device" mapstructure:"device"` // url, label, selector or @group
+	State  string `yaml:"state" mapstructure:"state"`
+	Op     string `yaml:"op" mapstructure:"op"` // see StateCompare, = by default
+	Value  string `yaml:"value" mapstructure:"value"`
+}
+
+// StateSource provides the devices and their current states to guards. Kiz is a StateSource.
+type StateSource interface {
+	GetSetup() (Setup, error)
+}
+
+// GuardError tells why a policy refused a command
+type GuardError struct {
+	Policy    string
+	DeviceURL DeviceURL
+	Command   string
+	Reason    string
+}
+
+func (e *GuardError) Error() string {
+	return fmt.Sprintf("Command %s on %s refused by policy %q: %s", e.Command, e.DeviceURL, e.Policy, e.Reason)
+}
+
+// Guard checks action groups against policies before they are executed
+type Guard struct {
+	policies []Policy
+	states   StateSource
+	groups   Groups
+}
+
+// NewGuard returns a guard for the policies. Devices are resolved and states read from the source
+// each time an action group is checked, groups are used to resolve @group device texts.
+func NewGuard(policies []Policy, states StateSource, groups Groups) (*Guard, error) {
+	for i, p := range policies {
+		if p.Name == "" {
+			policies[i].Name = fmt.Sprintf("policy %d", i+1)
+		}
+		if p.RefuseWhen == nil && p.Min == nil && p.Max == nil {
+			return nil, fmt.Errorf("Policy %q has no condition nor range", policies[i].Name)
+		}
+		if c := p.RefuseWhen; c != nil {
+			if c.Device == "" || c.State == "" {
+				return nil, fmt.Errorf("Policy %q: the condition needs a device and a state", policies[i].Name)
+			}
+			if _, err := StateCompare(defaultOp(c.Op), c.Value); err != nil {
+				return nil, fmt.Errorf("Policy %q: %w", policies[i].Name, err)
+			}
+		}
+	}
+	return &Guard{policies: policies, states: states, groups: groups}, nil
+}
+
+// defaultOp returns = for an empty comparison operator
+func defaultOp(op string) string {
+	if op == "" {
+		return "="
+	}
+	return op
+}
+
+// Check returns a *GuardError if a policy refuses one of the commands of the action group
+func (g *Guard) Check(ag ActionGroup) error {
+	if len(g.policies) == 0 {
+		return nil
+	}
+	setup, err := g.states.GetSetup()
+	if err != nil {
+		return err
+	}
+	for _, p := range g.policies {
+		guarded, err := g.devices(p.Devices, setup)
+		if err != nil {
+			return fmt.Errorf("Policy %q: %w", p.Name, err)
+		}
+		for _, a := range ag.Actions {
+			if !guarded[a.DeviceURL] {
+				continue
+			}
+			for _, c := range a.Commands {
+				if !guardsCommand(p, c.Name) {
+					continue
+				}
+				if reason, err := g.refuse(p, c, setup); err != nil {
+					return fmt.Errorf("Policy %q: %w", p.Name, err)
+				} else if reason != "" {
+					return &GuardError{Policy: p.Name, DeviceURL: a.DeviceURL, Command: c.Name, Reason: reason}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// devices returns the urls of the devices designated by text, all devices if text is empty
+func (g *Guard) devices(text string, setup Setup) (map[DeviceURL]bool, error) {
+	var devices []Device
+	var err error
+	switch {
+	case text == "":
+		devices = setup.Devices
+	case strings.HasPrefix(text, GroupPrefix):
+		devices, err = g.groups.Devices(text, setup)
+	default:
+		devices, err = DevicesFromSetupByText(text, setup)
+	}
+	if err != nil {
+		return nil, err
+	}
+	urls := make(map[DeviceURL]bool, len(devices))
+	for _, d := range devices {
+		urls[d.DeviceURL] = true
+	}
+	return urls, nil
+}
+
+// guardsCommand tells if the policy applies to the command
+func guardsCommand(p Policy, name string) bool {
+	if len(p.Commands) == 0 {
+		return true
+	}
+	for _, c := range p.Commands {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse returns why the policy refuses the command, empty if it does not
+func (g *Guard) refuse(p Policy, c Command, setup Setup) (string, error) {
+	if p.Min != nil || p.Max != nil {
+		if v, ok := firstParameter(c); ok {
+			if p.Min != nil && v < *p.Min {
+				return fmt.Sprintf("%v is below the minimum %v", v, *p.Min), nil
+			}
+			if p.Max != nil && v > *p.Max {
+				return fmt.Sprintf("%v is above the maximum %v", v, *p.Max), nil
+			}
+		}
+	}
+	cond := p.RefuseWhen
+	if cond == nil {
+		return "", nil
+	}
+	predicate, err := StateCompare(defaultOp(cond.Op), cond.Value)
+	if err != nil {
+		return "", err
+	}
+	var devices []Device
+	if strings.HasPrefix(cond.Device, GroupPrefix) {
+		devices, err = g.groups.Devices(cond.Device, setup)
+	} else {
+		devices, err = DevicesFromSetupByText(cond.Device, setup)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		for _, s := range d.States {
+			if string(s.Name) == cond.State && predicate(s) {
+				return fmt.Sprintf("%s %s is %v", d.Label, s.Name, s.Value), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// firstParameter returns the first parameter of the command as a number, if possible
+func firstParameter(c Command) (float64, bool) {
+	switch params := c.Parameters.(type) {
+	case []int:
+		if len(params) > 0 {
+			return float64(params[0]), true
+		}
+	case []interface{}:
+		if len(params) > 0 {
+			return DeviceState{Value: params[0]}.Float()
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,255 @@
+// Package history records events and device state changes in a SQLite database
+// and queries them. Its driver, mattn/go-sqlite3, needs cgo.
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// sqlite driver
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sgrimee/kizcool"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS events (
+	id         INTEGER PRIMARY KEY,
+	time       INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+CREATE TABLE IF NOT EXISTS event_devices (
+	event_id   INTEGER NOT NULL REFERENCES events (id),
+	device_url TEXT NOT NULL,
+	PRIMARY KEY (device_url, event_id)
+);
+CREATE TABLE IF NOT EXISTS states (
+	id         INTEGER PRIMARY KEY,
+	time       INTEGER NOT NULL,
+	device_url TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	type       INTEGER NOT NULL,
+	value      TEXT NOT NULL,
+	number     REAL
+);
+CREATE INDEX IF NOT EXISTS states_device_name_time ON states (device_url, name, time);
+`
+
+// Store records events in a SQLite database. It is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+}
+
+var _ kizcool.Sink = (*Store)(nil)
+
+// Open opens or creates the database at path
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error creating the database schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record stores the event and the state changes it carries.
+// Events without a timestamp are recorded at the current time.
+func (s *Store) Record(e kizcool.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	t := kizcool.EventTime(e)
+	if t.IsZero() {
+		t = time.Now()
+		if data, err = withTimestamp(data, t); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO events (time, name, data) VALUES (?, ?, ?)", millis(t), kizcool.EventName(e), string(data))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	// events of several devices, e.g. executions of several actions, are found with each of them
+	for _, url := range kizcool.EventDeviceURLs(e) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO event_devices (event_id, device_url) VALUES (?, ?)", id, string(url)); err != nil {
+			return err
+		}
+	}
+	for _, c := range kizcool.StateChanges(e) {
+		value, err := json.Marshal(c.Value)
+		if err != nil {
+			return err
+		}
+		var number interface{}
+		if f, ok := (kizcool.DeviceState{Type: c.Type, Value: c.Value}).Float(); ok && c.Type != kizcool.StateString {
+			number = f
+		}
+		if _, err := tx.Exec("INSERT INTO states (time, device_url, name, type, value, number) VALUES (?, ?, ?, ?, ?, ?)",
+			millis(t), string(c.DeviceURL), string(c.Name), int(c.Type), string(value), number); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query selects recorded state changes or events. Empty fields do not restrict the selection.
+type Query struct {
+	DeviceURLs []kizcool.DeviceURL
+	States     []kizcool.StateName // only for state changes
+	Since      time.Time
+	Until      time.Time
+	Limit      int // keep the most recent ones
+}
+
+// where returns the sql condition on the states or events table and its arguments
+func (q Query) where(table string) (string, []interface{}) {
+	conditions := []string{"1"}
+	var args []interface{}
+	in := func(column string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		list := "(?" + strings.Repeat(", ?", len(values)-1) + ")"
+		if table == "events" && column == "device_url" {
+			conditions = append(conditions, "id IN (SELECT event_id FROM event_devices WHERE device_url IN "+list+")")
+		} else {
+			conditions = append(conditions, column+" IN "+list)
+		}
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	var urls, names []string
+	for _, u := range q.DeviceURLs {
+		urls = append(urls, string(u))
+	}
+	for _, n := range q.States {
+		names = append(names, string(n))
+	}
+	in("device_url", urls)
+	in("name", names)
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, millis(q.Since))
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "time < ?")
+		args = append(args, millis(q.Until))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// selectSQL returns the query of the columns of the table, oldest first
+func (q Query) selectSQL(columns, table string) (string, []interface{}) {
+	where, args := q.where(table)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY time DESC, id DESC", columns, table, where)
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) ORDER BY time, id", query), args
+}
+
+// States returns the recorded state changes, oldest first
+func (s *Store) States(q Query) ([]kizcool.StateChange, error) {
+	query, args := q.selectSQL("id, time, device_url, name, type, value", "states")
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []kizcool.StateChange
+	for rows.Next() {
+		var id, ms int64
+		var url, name, value string
+		var c kizcool.StateChange
+		if err := rows.Scan(&id, &ms, &url, &name, &c.Type, &value); err != nil {
+			return nil, err
+		}
+		c.Time = time.Unix(0, ms*int64(time.Millisecond))
+		c.DeviceURL = kizcool.DeviceURL(url)
+		c.Name = kizcool.StateName(name)
+		if c.Value, err = decodeValue(c.Type, value); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// Events returns the recorded events, oldest first. Query.States is ignored.
+func (s *Store) Events(q Query) (kizcool.Events, error) {
+	q.States = nil
+	query, args := q.selectSQL("id, time, data", "events")
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var data []string
+	for rows.Next() {
+		var id, ms int64
+		var d string
+		if err := rows.Scan(&id, &ms, &d); err != nil {
+			return nil, err
+		}
+		data = append(data, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var events kizcool.Events
+	if err := json.Unmarshal([]byte("["+strings.Join(data, ",")+"]"), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// withTimestamp sets the timestamp of a json event
+func withTimestamp(data []byte, t time.Time) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["timestamp"] = json.RawMessage(fmt.Sprint(millis(t)))
+	return json.Marshal(fields)
+}
+
+// decodeValue decodes a json value, keeping integers as int
+func decodeValue(t kizcool.StateType, value string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, err
+	}
+	if f, ok := v.(float64); ok && t == kizcool.StateInt && f == float64(int(f)) {
+		return int(f), nil
+	}
+	return v, nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := Open(filepath.Join(dir, "events.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	const window = kizcool.DeviceURL("io://1111-0000-4444/11111111")
+	const light = kizcool.DeviceURL("io://1111-0000-4444/13523721")
+	base := time.Date(2019, 11, 20, 22, 0, 0, 0, time.UTC)
+	dsce := func(url kizcool.DeviceURL, minutes int, states ...kizcool.DeviceState) kizcool.Event {
+		return &kizcool.DeviceStateChangedEvent{
+			GenericEvent: kizcool.GenericEvent{
+				Name:      "DeviceStateChangedEvent",
+				Timestamp: int(base.Add(time.Duration(minutes)*time.Minute).UnixNano() / int64(time.Millisecond)),
+			},
+			DeviceURL:    url,
+			DeviceStates: states,
+		}
+	}
+	events := []kizcool.Event{
+		dsce(window, 0, kizcool.DeviceState{Name: "core:OpenClosedState", Type: kizcool.StateString, Value: "closed"},
+			kizcool.DeviceState{Name: "core:ClosureState", Type: kizcool.StateInt, Value: float64(100)}),
+		dsce(light, 10, kizcool.DeviceState{Name: "core:OnOffState", Type: kizcool.StateString, Value: "on"}),
+		dsce(window, 120, kizcool.DeviceState{Name: "core:OpenClosedState", Type: kizcool.StateString, Value: "open"},
+			kizcool.DeviceState{Name: "core:ClosureState", Type: kizcool.StateInt, Value: float64(30)}),
+		dsce(window, 125, kizcool.DeviceState{Name: "core:RSSILevelState", Type: kizcool.StateFloat, Value: "68.0"}),
+		&kizcool.GatewayAliveEvent{GenericEvent: kizcool.GenericEvent{Name: "GatewayAliveEvent"}},
+		&kizcool.ExecutionRegisteredEvent{
+			GenericEvent: kizcool.GenericEvent{Name: "ExecutionRegisteredEvent"},
+			Actions: []kizcool.Action{
+				{DeviceURL: window, Commands: []kizcool.Command{{Name: "close"}}},
+				{DeviceURL: light, Commands: []kizcool.Command{{Name: "off"}}},
+			},
+		},
+	}
+	for _, e := range events {
+		assert.NoError(t, store.Record(e))
+	}
+
+	changes, err := store.States(Query{DeviceURLs: []kizcool.DeviceURL{window}, States: []kizcool.StateName{"core:OpenClosedState"}})
+	assert.NoError(t, err)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, kizcool.StateChange{
+		Time:      base.Add(120 * time.Minute).Local(),
+		DeviceURL: window,
+		Name:      "core:OpenClosedState",
+		Type:      kizcool.StateString,
+		Value:     "open",
+	}, changes[1])
+
+	changes, err = store.States(Query{DeviceURLs: []kizcool.DeviceURL{window}, Since: base.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, 30, changes[1].Value, "int states are decoded as int")
+	assert.Equal(t, "68.0", changes[2].Value)
+
+	changes, err = store.States(Query{Until: base.Add(time.Hour), Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, light, changes[0].DeviceURL, "the limit keeps the most recent changes")
+
+	recorded, err := store.Events(Query{})
+	assert.NoError(t, err)
+	assert.Len(t, recorded, len(events))
+	assert.Equal(t, "GatewayAliveEvent", kizcool.EventName(recorded[4]))
+	assert.False(t, kizcool.EventTime(recorded[4]).IsZero(), "events without time are recorded at the current time")
+	recorded, err = store.Events(Query{DeviceURLs: []kizcool.DeviceURL{light}})
+	assert.NoError(t, err)
+	if assert.Len(t, recorded, 2) {
+		assert.Equal(t, "ExecutionRegisteredEvent", kizcool.EventName(recorded[1]))
+	}
+	recorded, err = store.Events(Query{DeviceURLs: []kizcool.DeviceURL{window, light}})
+	assert.NoError(t, err)
+	assert.Len(t, recorded, 5, "events of both devices are returned once")
+}
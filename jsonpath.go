@@ -0,0 +1,126 @@
+package kizcool
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath evaluates a simple JSONPath expression on the json representation of obj.
+// Supported steps are .field (case insensitive), .*, [index] and [*], with an optional leading $.
+// e.g. $.Definition.Commands[*].commandName
+func jsonPath(obj interface{}, path string) ([]interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var root interface{}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	nodes := []interface{}{root}
+	for _, step := range steps {
+		var next []interface{}
+		for _, n := range nodes {
+			next = append(next, step.apply(n)...)
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+// jsonPathStep is a field name, an index or a wildcard
+type jsonPathStep struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func (s jsonPathStep) apply(node interface{}) []interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			var values []interface{}
+			for _, v := range n {
+				values = append(values, v)
+			}
+			return values
+		}
+		if s.isIndex {
+			return nil
+		}
+		if v, ok := n[s.field]; ok {
+			return []interface{}{v}
+		}
+		for k, v := range n {
+			if strings.EqualFold(k, s.field) {
+				return []interface{}{v}
+			}
+		}
+	case []interface{}:
+		if s.wildcard {
+			return n
+		}
+		if s.isIndex {
+			i := s.index
+			if i < 0 {
+				i += len(n)
+			}
+			if i >= 0 && i < len(n) {
+				return []interface{}{n[i]}
+			}
+		}
+	}
+	return nil
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(path, "{"), "}"), "$")
+	if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+	var steps []jsonPathStep
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			j := i + 1
+			for j < len(path) && path[j] != '.' && path[j] != '[' {
+				j++
+			}
+			name := path[i+1 : j]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("Empty field name in jsonpath %q", path)
+			case "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			default:
+				steps = append(steps, jsonPathStep{field: name})
+			}
+			i = j
+		case '[':
+			j := strings.IndexByte(path[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("Missing ] in jsonpath %q", path)
+			}
+			inner := strings.Trim(path[i+1:i+j], `'"`)
+			if inner == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else if n, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, jsonPathStep{index: n, isIndex: true})
+			} else {
+				steps = append(steps, jsonPathStep{field: inner})
+			}
+			i += j + 1
+		default:
+			return nil, fmt.Errorf("Unexpected %q in jsonpath %q", path[i], path)
+		}
+	}
+	return steps, nil
+}
@@ -1,8 +1,13 @@
 package kizcool
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
 	"regexp"
 	"strings"
 	"time"
@@ -13,25 +18,134 @@ import (
 
 // Kiz high-level client
 type Kiz struct {
-	clt *api.Client
+	clt    *api.Client
+	groups Groups
+	guard  *Guard
+	dryRun io.Writer
+	logger api.Logger
 }
 
-// New returns an initialized Kiz
-// sessionID is optional and used for external caching of sessions
-func New(username, password, baseURL, sessionID string) (*Kiz, error) {
-	clt, err := api.New(username, password, baseURL, sessionID)
-	if err != nil {
-		return nil, err
+// Option configures a Kiz, see NewClient
+type Option func(*options)
+
+type options struct {
+	client *api.Client
+	logger api.Logger
+	api    []api.Option
+}
+
+// WithAPIClient makes Kiz use an existing api client. The options of the api client are then ignored.
+func WithAPIClient(c *api.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithCredentials sets the username and password used to login, see api.WithCredentials
+func WithCredentials(username, password string) Option {
+	return apiOption(api.WithCredentials(username, password))
+}
+
+// WithBaseURL sets the url of the server, see api.WithBaseURL
+func WithBaseURL(baseURL string) Option {
+	return apiOption(api.WithBaseURL(baseURL))
+}
+
+// WithHTTPClient sets the http client used to send requests, see api.WithHTTPClient
+func WithHTTPClient(hc *http.Client) Option {
+	return apiOption(api.WithHTTPClient(hc))
+}
+
+// WithSessionStore sets where the session ID is kept between runs, see api.WithSessionStore
+func WithSessionStore(store api.SessionStore) Option {
+	return apiOption(api.WithSessionStore(store))
+}
+
+// WithUserAgent sets the User-Agent header of the requests, see api.WithUserAgent
+func WithUserAgent(userAgent string) Option {
+	return apiOption(api.WithUserAgent(userAgent))
+}
+
+// WithTimeout sets the time limit of each request, see api.WithTimeout
+func WithTimeout(timeout time.Duration) Option {
+	return apiOption(api.WithTimeout(timeout))
+}
+
+// WithRetryPolicy makes the client retry failed requests, see api.WithRetryPolicy
+func WithRetryPolicy(p api.RetryPolicy) Option {
+	return apiOption(api.WithRetryPolicy(p))
+}
+
+// WithLogger makes Kiz and its api client log to l
+func WithLogger(l api.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+		o.api = append(o.api, api.WithLogger(l))
+	}
+}
+
+// apiOption returns an option passed to the api client
+func apiOption(opt api.Option) Option {
+	return func(o *options) {
+		o.api = append(o.api, opt)
 	}
-	return NewWithAPIClient(clt)
 }
 
-// NewWithAPIClient returns an initialized Kiz from an existing API client
-func NewWithAPIClient(c *api.Client) (*Kiz, error) {
-	k := Kiz{
-		clt: c,
+// NewClient returns a Kiz configured with the options. WithBaseURL is required unless WithAPIClient is given.
+func NewClient(opts ...Option) (*Kiz, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	return &k, nil
+	clt := o.client
+	if clt == nil {
+		var err error
+		if clt, err = api.NewClient(o.api...); err != nil {
+			return nil, err
+		}
+	}
+	logger := o.logger
+	if logger == nil {
+		logger = clt.Logger()
+	}
+	return &Kiz{clt: clt, logger: logger}, nil
+}
+
+// New returns an initialized Kiz
+// sessionID is optional and used for external caching of sessions
+func New(username, password, baseURL, sessionID string, opts ...Option) (*Kiz, error) {
+	return NewClient(append([]Option{
+		WithCredentials(username, password),
+		WithBaseURL(baseURL),
+		WithSessionStore(api.NewMemorySessionStore(sessionID)),
+	}, opts...)...)
+}
+
+// NewWithAPIClient returns an initialized Kiz from an existing API client.
+// It logs to the logger of the client unless WithLogger is given.
+func NewWithAPIClient(c *api.Client, opts ...Option) (*Kiz, error) {
+	return NewClient(append(opts, WithAPIClient(c))...)
+}
+
+// SetGroups sets the device groups that can be designated with @name in device texts
+func (k *Kiz) SetGroups(groups Groups) {
+	k.groups = groups
+}
+
+// Groups returns the device groups
+func (k *Kiz) Groups() Groups {
+	return k.groups
+}
+
+// SetGuard sets the guard checking action groups before they are executed, nil to disable it
+func (k *Kiz) SetGuard(g *Guard) {
+	k.guard = g
+}
+
+// SetDryRun makes Execute, ExecuteActionGroup and the refresh methods validate and print to w
+// what they would send, instead of sending it. nil disables the dry run.
+func (k *Kiz) SetDryRun(w io.Writer) {
+	k.dryRun = w
 }
 
 // SessionID is the latest known sessionID value
@@ -46,6 +160,25 @@ func (k *Kiz) Login() error {
 	return k.clt.Login()
 }
 
+// Stats returns the counters of requests made to the api server
+func (k *Kiz) Stats() api.Stats {
+	return k.clt.Stats()
+}
+
+// GetSetup returns the whole setup, including devices and places
+func (k *Kiz) GetSetup() (Setup, error) {
+	resp, err := k.clt.GetSetup()
+	if err != nil {
+		return Setup{}, err
+	}
+	defer resp.Body.Close()
+	var result Setup
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Setup{}, err
+	}
+	return result, nil
+}
+
 // GetDevices returns the list of devices
 func (k *Kiz) GetDevices() ([]Device, error) {
 	resp, err := k.clt.GetDevices()
@@ -70,29 +203,40 @@ func (k *Kiz) GetDevice(deviceURL DeviceURL) (Device, error) {
 	return result, nil
 }
 
+// validDeviceURL matches the text of a DeviceURL
+var validDeviceURL = regexp.MustCompile(`^[a-z]+://\d{4}-\d{4}-\d{4}/\d+`)
+
 // DeviceFromListByLabel tries to match the given string to the Labels of the given devices
-// and returns the found Device. An error is return is zero or more than one devices match.
+// and returns the found Device. A *LabelError is returned if zero or more than one devices match,
+// with the ambiguous devices or the closest labels as candidates.
 func DeviceFromListByLabel(label string, devices []Device) (Device, error) {
-	var foundDevice Device
+	var found []Device
 	for _, d := range devices {
-		if strings.Compare(strings.ToLower(d.Label), strings.ToLower(label)) == 0 {
-			if foundDevice.DeviceURL != "" {
-				return Device{}, errors.New("More than one device with that label")
-			}
-			foundDevice = d
+		if strings.EqualFold(d.Label, label) {
+			found = append(found, d)
 		}
 	}
-	if foundDevice.DeviceURL == "" {
-		return Device{}, errors.New("No device with that label")
+	switch len(found) {
+	case 1:
+		return found[0], nil
+	case 0:
+		return Device{}, &LabelError{
+			Label:      label,
+			Candidates: SuggestDevices(label, devices, maxSuggestions),
+		}
+	default:
+		return Device{}, &LabelError{
+			Label:      label,
+			Ambiguous:  true,
+			Candidates: found,
+		}
 	}
-	return foundDevice, nil
 }
 
 // GetDeviceByText returns a Device from a text string
 // If first tries to match a DeviceURL. If no match, it tries to match a device Label
 func (k *Kiz) GetDeviceByText(text string) (Device, error) {
-	validURL := regexp.MustCompile(`^[a-z]+://\d{4}-\d{4}-\d{4}/\d+`)
-	if validURL.MatchString(text) {
+	if validDeviceURL.MatchString(text) {
 		// a DeviceURL was given
 		device, err := k.GetDevice(DeviceURL(text))
 		if err != nil {
@@ -112,6 +256,56 @@ func (k *Kiz) GetDeviceByText(text string) (Device, error) {
 	return device, nil
 }
 
+// GetDevicesByText returns the Devices designated by a text string
+// It can be a DeviceURL, a device Label, a selector expression (see Selector) or a group
+// prefixed with @ (see SetGroups). An exact Label match takes precedence over a selector.
+func (k *Kiz) GetDevicesByText(text string) ([]Device, error) {
+	if validDeviceURL.MatchString(text) {
+		device, err := k.GetDevice(DeviceURL(text))
+		if err != nil {
+			return nil, err
+		}
+		return []Device{device}, nil
+	}
+	setup, err := k.GetSetup()
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(text, GroupPrefix) {
+		return k.groups.Devices(text, setup)
+	}
+	return DevicesFromSetupByText(text, setup)
+}
+
+// DevicesFromSetupByText returns the Devices of the setup designated by a text string,
+// like GetDevicesByText but without calling the server.
+func DevicesFromSetupByText(text string, setup Setup) ([]Device, error) {
+	if validDeviceURL.MatchString(text) {
+		for _, d := range setup.Devices {
+			if string(d.DeviceURL) == text {
+				return []Device{d}, nil
+			}
+		}
+		return nil, fmt.Errorf("No device with URL %s", text)
+	}
+	device, labelErr := DeviceFromListByLabel(text, setup.Devices)
+	if labelErr == nil {
+		return []Device{device}, nil
+	}
+	if _, err := ParseSelector(text); err != nil {
+		// not a selector either, the label error is more meaningful
+		return nil, labelErr
+	}
+	devices, err := SelectInSetup(setup, text)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("No device matches the selector")
+	}
+	return devices, nil
+}
+
 // GetDeviceState returns the current state with name stateName for the device with URL deviceURL
 func (k *Kiz) GetDeviceState(deviceURL DeviceURL, stateName StateName) (DeviceState, error) {
 	resp, err := k.clt.GetDeviceState(string(deviceURL), string(stateName))
@@ -126,9 +320,132 @@ func (k *Kiz) GetDeviceState(deviceURL DeviceURL, stateName StateName) (DeviceSt
 
 // RefreshStates tells the server send the state of all devices as events
 func (k *Kiz) RefreshStates() error {
+	if k.dryRun != nil {
+		_, err := fmt.Fprintln(k.dryRun, "Would refresh the states of all devices")
+		return err
+	}
 	return k.clt.RefreshStates()
 }
 
+// RefreshCommand returns the command asking the device to report the current value of
+// the given state, e.g. refreshIntrusionDetected for core:IntrusionDetectedState.
+// It returns false if the device does not support such a command.
+func RefreshCommand(device Device, stateName StateName) (Command, bool) {
+	name := string(stateName)
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, "State")
+	for _, cd := range device.Definition.Commands {
+		if strings.EqualFold(cd.CommandName, "refresh"+name) {
+			return Command{Name: cd.CommandName}, true
+		}
+	}
+	return Command{}, false
+}
+
+// ErrNoRefreshCommand is returned by RefreshDeviceStates when the device has no refresh command at all
+var ErrNoRefreshCommand = errors.New("Device has no refresh command")
+
+// UnrefreshedStatesError is returned by RefreshDeviceStates for the states the device has no refresh
+// command for. The other states were refreshed, the current value of these ones can still be read.
+type UnrefreshedStatesError struct {
+	States []StateName
+}
+
+func (e *UnrefreshedStatesError) Error() string {
+	names := make([]string, len(e.States))
+	for i, name := range e.States {
+		names[i] = string(name)
+	}
+	return fmt.Sprintf("Device has no refresh command for %s", strings.Join(names, ", "))
+}
+
+// RefreshDeviceStates sends the refresh commands of the device for the given states,
+// or all its refresh commands if no state is given, then waits for their execution to end.
+// States without a refresh command are reported with an *UnrefreshedStatesError, and
+// ErrNoRefreshCommand is returned if the device has no refresh command at all.
+func (k *Kiz) RefreshDeviceStates(ctx context.Context, device Device, stateNames ...StateName) error {
+	var all []Command
+	for _, cd := range device.Definition.Commands {
+		if strings.HasPrefix(cd.CommandName, "refresh") && cd.Nparams == 0 {
+			all = append(all, Command{Name: cd.CommandName})
+		}
+	}
+	if len(all) == 0 {
+		return ErrNoRefreshCommand
+	}
+	commands := all
+	var unrefreshed []StateName
+	if len(stateNames) > 0 {
+		commands = nil
+		for _, name := range stateNames {
+			if cmd, ok := RefreshCommand(device, name); ok {
+				commands = append(commands, cmd)
+			} else {
+				unrefreshed = append(unrefreshed, name)
+			}
+		}
+	}
+	if err := k.refresh(ctx, device, commands); err != nil {
+		return err
+	}
+	if len(unrefreshed) > 0 {
+		return &UnrefreshedStatesError{States: unrefreshed}
+	}
+	return nil
+}
+
+// refresh executes the refresh commands on the device and waits for the end of the execution
+func (k *Kiz) refresh(ctx context.Context, device Device, commands []Command) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	ag := ActionGroup{Actions: []Action{{DeviceURL: device.DeviceURL, Commands: commands}}}
+	if k.dryRun != nil {
+		// nothing is executed, so there is no execution to wait for
+		_, err := k.Execute(ag)
+		return err
+	}
+	// poll once before executing so that the listener is registered and the end of execution is seen
+	if _, err := k.PollEvents(); err != nil {
+		return err
+	}
+	id, err := k.Execute(ag)
+	if err != nil {
+		return err
+	}
+	return k.waitForExecution(ctx, id)
+}
+
+// waitForExecution polls events until the execution with the given id is over
+func (k *Kiz) waitForExecution(ctx context.Context, id ExecID) error {
+	const sleepTime = 1 * time.Second
+	for {
+		events, err := k.PollEvents()
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			esce, ok := e.(*ExecutionStateChangedEvent)
+			if !ok || esce.ExecID != id {
+				continue
+			}
+			switch esce.NewState {
+			case "COMPLETED":
+				return nil
+			case "FAILED":
+				return fmt.Errorf("Execution %s failed", id)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleepTime):
+		}
+	}
+}
+
 // GetActionGroups returns the list of action groups defined on the box
 func (k *Kiz) GetActionGroups() ([]ActionGroup, error) {
 	resp, err := k.clt.GetActionGroups()
@@ -141,6 +458,20 @@ func (k *Kiz) GetActionGroups() ([]ActionGroup, error) {
 	return result, nil
 }
 
+// GetCurrentExecutions returns the executions in progress
+func (k *Kiz) GetCurrentExecutions() ([]Execution, error) {
+	resp, err := k.clt.GetCurrentExecutions()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result []Execution
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // SupportsCommand returns true if the command is supported by the device.
 func SupportsCommand(device Device, command Command) bool {
 	for _, supportedCommand := range device.Definition.Commands {
@@ -151,6 +482,53 @@ func SupportsCommand(device Device, command Command) bool {
 	return false
 }
 
+// ValidateActionGroup checks that the devices of the action group exist, support the commands
+// and get the number of parameters they expect
+func ValidateActionGroup(ag ActionGroup, devices []Device) error {
+	byURL := make(map[DeviceURL]Device, len(devices))
+	for _, d := range devices {
+		byURL[d.DeviceURL] = d
+	}
+	for _, a := range ag.Actions {
+		device, ok := byURL[a.DeviceURL]
+		if !ok {
+			return fmt.Errorf("No device with URL %s", a.DeviceURL)
+		}
+		for _, c := range a.Commands {
+			def, ok := commandDefinition(device, c.Name)
+			if !ok {
+				return fmt.Errorf("Device %s does not support command %s", device.Label, c.Name)
+			}
+			if n := parameterCount(c); n != def.Nparams {
+				return fmt.Errorf("Command %s of device %s expects %d parameters, got %d", c.Name, device.Label, def.Nparams, n)
+			}
+		}
+	}
+	return nil
+}
+
+// commandDefinition returns the definition of the named command of the device
+func commandDefinition(device Device, name string) (CommandDefinition, bool) {
+	for _, def := range device.Definition.Commands {
+		if def.CommandName == name {
+			return def, true
+		}
+	}
+	return CommandDefinition{}, false
+}
+
+// parameterCount returns the number of parameters of the command
+func parameterCount(c Command) int {
+	if c.Parameters == nil {
+		return 0
+	}
+	v := reflect.ValueOf(c.Parameters)
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		return v.Len()
+	}
+	return 1
+}
+
 // ActionGroupWithOneCommand returns an action group with a single command for the device
 func ActionGroupWithOneCommand(device Device, command Command) (ActionGroup, error) {
 	if !SupportsCommand(device, command) {
@@ -166,8 +544,33 @@ func ActionGroupWithOneCommand(device Device, command Command) (ActionGroup, err
 	return actionGroup, nil
 }
 
-// Execute runs an action group and returns a (job) ExecID
+// ActionGroupWithCommand returns an action group sending the same command to all the devices
+func ActionGroupWithCommand(devices []Device, command Command) (ActionGroup, error) {
+	var actionGroup ActionGroup
+	for _, device := range devices {
+		if !SupportsCommand(device, command) {
+			return ActionGroup{}, fmt.Errorf("Device %s does not support command %s", device.Label, command.Name)
+		}
+		actionGroup.Actions = append(actionGroup.Actions, Action{
+			DeviceURL: device.DeviceURL,
+			Commands:  []Command{command},
+		})
+	}
+	return actionGroup, nil
+}
+
+// Execute runs an action group and returns a (job) ExecID.
+// If a guard is set, the action group is refused with a *GuardError when a policy forbids it.
 func (k *Kiz) Execute(ag ActionGroup) (ExecID, error) {
+	if k.guard != nil {
+		if err := k.guard.Check(ag); err != nil {
+			k.logger.Info("Command refused by guard", "err", err)
+			return "", err
+		}
+	}
+	if k.dryRun != nil {
+		return "", k.printDryRun(ag)
+	}
 	jsonStr, err := json.Marshal(ag)
 	if err != nil {
 		return "", err
@@ -176,11 +579,68 @@ func (k *Kiz) Execute(ag ActionGroup) (ExecID, error) {
 	if err != nil {
 		return "", err
 	}
+	return decodeExecID(resp.Body)
+}
+
+// ExecuteActionGroup runs the action group (scenario) stored on the server with the given OID.
+// If a guard is set, the actions of the scenario are checked like those of Execute.
+func (k *Kiz) ExecuteActionGroup(oid string) (ExecID, error) {
+	if k.guard != nil && len(k.guard.policies) > 0 {
+		if err := k.checkActionGroup(oid); err != nil {
+			k.logger.Info("Scenario refused by guard", "oid", oid, "err", err)
+			return "", err
+		}
+	}
+	if k.dryRun != nil {
+		_, err := fmt.Fprintf(k.dryRun, "Would execute action group %s\n", oid)
+		return "", err
+	}
+	resp, err := k.clt.ExecuteActionGroup(oid)
+	if err != nil {
+		return "", err
+	}
+	return decodeExecID(resp.Body)
+}
+
+// checkActionGroup checks the actions of the stored action group against the guard
+func (k *Kiz) checkActionGroup(oid string) error {
+	ags, err := k.GetActionGroups()
+	if err != nil {
+		return err
+	}
+	for _, ag := range ags {
+		if ag.OID == oid {
+			return k.guard.Check(ag)
+		}
+	}
+	return fmt.Errorf("Unknown action group %s", oid)
+}
+
+// printDryRun validates the action group against the current devices and prints it as json
+func (k *Kiz) printDryRun(ag ActionGroup) error {
+	devices, err := k.GetDevices()
+	if err != nil {
+		return err
+	}
+	if err := ValidateActionGroup(ag, devices); err != nil {
+		return err
+	}
+	jsonStr, err := json.MarshalIndent(ag, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(k.dryRun, "%s\n", jsonStr)
+	return err
+}
+
+// decodeExecID decodes the response to an execution and closes it
+func decodeExecID(body io.ReadCloser) (ExecID, error) {
+	defer body.Close()
 	type Result struct {
 		ExecID ExecID
 	}
 	var result Result
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
 		return "", err
 	}
 	return result.ExecID, nil
@@ -261,8 +721,14 @@ func (k *Kiz) PollEvents() (Events, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error getting events: %v", err)
 	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading events: %v", err)
+	}
 	var result Events
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(data, &result); err != nil {
+		k.logger.Debug("Error decoding events", "err", err, "data", string(data))
 		return nil, fmt.Errorf("Error decoding events from json: %v", err)
 	}
 	return result, nil
@@ -282,26 +748,92 @@ func (k *Kiz) PollEventsContinuousWithSleepTime(ev chan<- Event, e chan<- error,
 	const refreshStatesEvery = 30 * time.Minute
 	const delayBeforeResumingPolling = 40 * time.Second
 	refreshTicker := time.NewTicker(refreshStatesEvery)
+	defer refreshTicker.Stop()
+	// sendError returns false if finish was closed before the error was received
+	sendError := func(err error) bool {
+		select {
+		case e <- err:
+			return true
+		case <-finish:
+			return false
+		}
+	}
 	for {
 		events, err := k.PollEvents()
 		if err != nil {
-			e <- err
-			time.Sleep(delayBeforeResumingPolling)
+			if !sendError(err) {
+				return
+			}
+			select {
+			case <-time.After(delayBeforeResumingPolling):
+			case <-finish:
+				return
+			}
 		}
-		for _, e := range events {
-			ev <- e
+		for _, event := range events {
+			select {
+			case ev <- event:
+			case <-finish:
+				return
+			}
 		}
 		select {
 		case <-refreshTicker.C:
-			err := k.RefreshStates()
-			if err != nil {
-				e <- err
+			if err := k.RefreshStates(); err != nil && !sendError(err) {
+				return
 			}
+		case <-time.After(sleepTime):
 		case <-finish:
-			refreshTicker.Stop()
 			return
-		default:
-			time.Sleep(sleepTime)
+		}
+	}
+}
+
+// WaitForState calls WaitForStateWithSleepTime with a reasonable polling interval
+func (k *Kiz) WaitForState(ctx context.Context, device Device, stateName StateName, predicate StatePredicate) (DeviceState, error) {
+	const sleepTimeDefault = 2 * time.Second
+	return k.WaitForStateWithSleepTime(ctx, device, stateName, predicate, sleepTimeDefault)
+}
+
+// WaitForStateWithSleepTime waits until the state stateName of the device satisfies the predicate
+// and returns that state. The current value is read first, then events are polled at given intervals.
+// If the context is done before, the last known state is returned with the context error.
+func (k *Kiz) WaitForStateWithSleepTime(ctx context.Context, device Device, stateName StateName, predicate StatePredicate, sleepTime time.Duration) (DeviceState, error) {
+	// poll once before reading the state so that the listener is registered and no change is missed
+	if _, err := k.PollEvents(); err != nil {
+		return DeviceState{}, err
+	}
+	state, err := k.GetDeviceState(device.DeviceURL, stateName)
+	if err != nil {
+		return DeviceState{}, err
+	}
+	for {
+		if predicate(state) {
+			return state, nil
+		}
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-time.After(sleepTime):
+		}
+		events, err := k.PollEvents()
+		if err != nil {
+			return state, err
+		}
+		for _, e := range events {
+			dsce, ok := e.(*DeviceStateChangedEvent)
+			if !ok || dsce.DeviceURL != device.DeviceURL {
+				continue
+			}
+			for _, s := range dsce.DeviceStates {
+				if s.Name != stateName {
+					continue
+				}
+				state = s
+				if predicate(state) {
+					return state, nil
+				}
+			}
 		}
 	}
 }
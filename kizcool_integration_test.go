@@ -4,6 +4,7 @@ package kizcool
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"testing"
 
@@ -14,20 +15,49 @@ import (
 
 var kiz *Kiz
 
+// transport records the interactions to the cassette file in KIZ_RECORD, or replays
+// those of KIZ_REPLAY to run the tests offline
+var transport http.RoundTripper
+
 func TestMain(m *testing.M) {
 	err := config.Read(false)
 	if err != nil {
 		log.Fatal(err)
 	}
-	kiz, err = New(config.Username(), config.Password(), config.BaseURL(), "")
+	var recorder *api.Recorder
+	if path := os.Getenv("KIZ_REPLAY"); path != "" {
+		cassette, err := api.LoadCassette(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport = api.NewReplayer(cassette)
+	} else if os.Getenv("KIZ_RECORD") != "" {
+		recorder = api.NewRecorder(nil)
+		transport = recorder
+	}
+	kiz, err = newIntKiz(config.Username(), config.Password())
 	if err != nil {
 		log.Fatal(err)
 	}
-	os.Exit(m.Run())
+	code := m.Run()
+	if recorder != nil {
+		if err := recorder.Save(os.Getenv("KIZ_RECORD")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	os.Exit(code)
+}
+
+func newIntKiz(username, password string) (*Kiz, error) {
+	clt, err := api.NewWithHTTPClient(username, password, config.BaseURL(), "", &http.Client{Transport: transport})
+	if err != nil {
+		return nil, err
+	}
+	return NewWithAPIClient(clt)
 }
 
 func TestIntBadLogin(t *testing.T) {
-	k, err := New("baduser", "badpass", config.BaseURL(), "")
+	k, err := newIntKiz("baduser", "badpass")
 	assert.NoError(t, err)
 	err = k.Login()
 	assert.Error(t, err)
@@ -1,12 +1,15 @@
 package kizcool
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,6 +43,19 @@ func TestNewEmptyURL(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestNewClient(t *testing.T) {
+	kiz, err := NewClient(
+		WithBaseURL("http://bogus.org"),
+		WithSessionStore(api.NewMemorySessionStore("stored_session_id")),
+		WithTimeout(time.Second),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "stored_session_id", kiz.SessionID())
+
+	_, err = NewClient(WithCredentials("user", "pass"))
+	assert.Error(t, err, "the base url is required")
+}
+
 func TestSessionID(t *testing.T) {
 	const sessionID = "test_session_id"
 	kiz, _ := New("", "", "http://bogus.org", sessionID)
@@ -296,3 +312,821 @@ func TestPollEventsContinuous(t *testing.T) {
 		}
 	}
 }
+
+func TestPollEventsContinuousFinish(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write(helperLoadBytes(t, "pollEvents.json"))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+
+	finish := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		// nothing reads the events, the send must not block once finish is closed
+		kiz.PollEventsContinuousWithSleepTime(make(chan Event), make(chan error), finish, time.Millisecond)
+		close(done)
+	}()
+	close(finish)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Polling did not stop when finish was closed")
+	}
+}
+
+func TestListenEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write(helperLoadBytes(t, "pollEvents.json"))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := ListenEvents(ctx, kiz, nil)
+	_, ok := <-events
+	assert.True(t, ok)
+	cancel()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("The events channel was not closed when the context was done")
+		}
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	var events Events
+	assert.NoError(t, json.Unmarshal(helperLoadBytes(t, "pollEvents.json"), &events))
+
+	count := func(f EventFilter) int {
+		n := 0
+		for _, e := range events {
+			if _, ok := f.Filter(e); ok {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, len(events), count(EventFilter{}))
+	assert.Equal(t, 1, count(EventFilter{Names: []string{"ExecutionRegisteredEvent"}}))
+	assert.Equal(t, 0, count(EventFilter{DeviceURLs: []DeviceURL{"io://bogus"}}))
+
+	dsce := &DeviceStateChangedEvent{
+		DeviceURL: "io://1111-0000-4444/11111111",
+		DeviceStates: []DeviceState{
+			{Name: "core:OnOffState", Type: StateString, Value: "on"},
+			{Name: "core:LightIntensityState", Type: StateInt, Value: 100.0},
+		},
+	}
+	f := EventFilter{
+		DeviceURLs: []DeviceURL{"io://1111-0000-4444/11111111"},
+		States:     []StateName{"core:OnOffState"},
+	}
+	e, ok := f.Filter(dsce)
+	assert.True(t, ok)
+	assert.Len(t, e.(*DeviceStateChangedEvent).DeviceStates, 1)
+	assert.Len(t, dsce.DeviceStates, 2, "the original event must not be modified")
+
+	_, ok = EventFilter{States: []StateName{"core:ClosureState"}}.Filter(dsce)
+	assert.False(t, ok)
+}
+
+func TestOutputTextEvent(t *testing.T) {
+	e := &DeviceStateChangedEvent{
+		GenericEvent: GenericEvent{Name: "DeviceStateChangedEvent", Timestamp: 1574106269793},
+		DeviceURL:    "io://1111-0000-4444/11111111",
+		DeviceStates: []DeviceState{{Name: "core:OnOffState", Type: StateString, Value: "on"}},
+	}
+	var b strings.Builder
+	assert.NoError(t, Output(&b, "text", e))
+	assert.Contains(t, b.String(), "DeviceStateChangedEvent io://1111-0000-4444/11111111 core:OnOffState=on\n")
+
+	b.Reset()
+	assert.NoError(t, Output(&b, "json", e))
+	assert.Equal(t, 1, strings.Count(b.String(), "\n"), "json output must be one event per line")
+}
+
+func TestStateCompare(t *testing.T) {
+	var tests = []struct {
+		op    string
+		value string
+		state interface{}
+		want  bool
+	}{
+		{"=", "open", "open", true},
+		{"=", "open", "closed", false},
+		{"!=", "open", "closed", true},
+		{"=", "0", 0.0, true},
+		{"==", "100", 100.0, true},
+		{"<", "50", 20.0, true},
+		{"<=", "50", 50.0, true},
+		{">", "50", 50.0, false},
+		{">=", "50", "75", true},
+		{">", "50", "closed", false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v %s %s", tt.state, tt.op, tt.value), func(t *testing.T) {
+			predicate, err := StateCompare(tt.op, tt.value)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, predicate(DeviceState{Value: tt.state}))
+		})
+	}
+	_, err := StateCompare("<", "open")
+	assert.Error(t, err)
+	_, err = StateCompare("~", "open")
+	assert.Error(t, err)
+}
+
+func TestWaitForState(t *testing.T) {
+	const url = "io://1111-0000-4444/12345678"
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/enduserAPI/setup/devices/" + url + "/states/core:OpenClosedState":
+			rw.Write([]byte(`{"name": "core:OpenClosedState","type": 3,"value": "closed"}`))
+		case "/enduserAPI/events/not_empty/fetch":
+			rw.Write([]byte(`[{"name": "DeviceStateChangedEvent", "deviceURL": "` + url + `",
+				"deviceStates": [{"name": "core:OpenClosedState", "type": 3, "value": "open"}]}]`))
+		}
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	device := Device{DeviceURL: url}
+
+	open, _ := StateCompare("=", "open")
+	state, err := kiz.WaitForStateWithSleepTime(context.Background(), device, "core:OpenClosedState", open, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "open", state.Value)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	halfOpen, _ := StateCompare("=", "half")
+	_, err = kiz.WaitForStateWithSleepTime(ctx, device, "core:OpenClosedState", halfOpen, time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestRefreshCommand(t *testing.T) {
+	device := Device{
+		Definition: DeviceDefinition{
+			Commands: []CommandDefinition{
+				{CommandName: "refreshIntrusionDetected"},
+				{CommandName: "refreshCurrentAlarmMode"},
+			},
+		},
+	}
+	cmd, ok := RefreshCommand(device, "core:IntrusionDetectedState")
+	assert.True(t, ok)
+	assert.Equal(t, "refreshIntrusionDetected", cmd.Name)
+	_, ok = RefreshCommand(device, "core:ClosureState")
+	assert.False(t, ok)
+}
+
+func TestRefreshDeviceStates(t *testing.T) {
+	const execID = "133a5c55-3655-5455-2355-c33e43535e55"
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/enduserAPI/exec/apply":
+			var ag ActionGroup
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&ag))
+			assert.Equal(t, "refreshCurrentAlarmMode", ag.Actions[0].Commands[0].Name)
+			rw.Write([]byte(`{"execId": "` + execID + `"}`))
+		case "/enduserAPI/events/not_empty/fetch":
+			rw.Write([]byte(`[{"name": "ExecutionStateChangedEvent", "execId": "` + execID + `",
+				"oldState": "IN_PROGRESS", "newState": "COMPLETED"}]`))
+		}
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	device := Device{
+		DeviceURL: "internal://1111-0000-4444/alarm/0",
+		Definition: DeviceDefinition{
+			Commands: []CommandDefinition{{CommandName: "refreshCurrentAlarmMode"}},
+		},
+	}
+	assert.NoError(t, kiz.RefreshDeviceStates(context.Background(), device, "core:CurrentAlarmModeState"))
+	err := kiz.RefreshDeviceStates(context.Background(), device, "core:ClosureState", "core:CurrentAlarmModeState")
+	if assert.IsType(t, &UnrefreshedStatesError{}, err) {
+		assert.Equal(t, []StateName{"core:ClosureState"}, err.(*UnrefreshedStatesError).States)
+	}
+	device.Definition.Commands = nil
+	assert.Equal(t, ErrNoRefreshCommand, kiz.RefreshDeviceStates(context.Background(), device, "core:ClosureState"))
+}
+
+// helperLoadSetup loads the test setup, as returned by the /setup endpoint
+func helperLoadSetup(t *testing.T) Setup {
+	var data struct {
+		Setup Setup
+	}
+	assert.NoError(t, json.Unmarshal(helperLoadBytes(t, "getSetup.json"), &data))
+	return data.Setup
+}
+
+func TestSelectInSetup(t *testing.T) {
+	setup := helperLoadSetup(t)
+	var tests = []struct {
+		expr  string
+		count int
+	}{
+		{"all", 19},
+		{"class=RollerShutter", 5},
+		{`room="Ch Parents"`, 9},
+		{`room="1er étage" and class=Light`, 6},
+		{`label~"Volet*" and state:core:OpenClosedState=open`, 5},
+		{"label~/nils$/", 3},
+		{"class=Window and not state:core:ClosureState<100", 6},
+		{"class=Light or class=Window", 12},
+		{"(class=Light or class=Window) and room!=6cb0cfb7-ee3e-428f-bc8c-c8938b36c4e5", 6},
+		{`url~"internal://*"`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			devices, err := SelectInSetup(setup, tt.expr)
+			assert.NoError(t, err)
+			assert.Len(t, devices, tt.count)
+		})
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	for _, expr := range []string{"", "label=", "label=foo and", "(class=Light", "bogus=1", `label~"open`, "class<3", "Volet Nils"} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := Select(nil, expr)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetDevicesByText(t *testing.T) {
+	setup := helperLoadSetup(t)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/setup", req.URL.String())
+		json.NewEncoder(rw).Encode(setup)
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+
+	devices, err := kiz.GetDevicesByText("volet nils")
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+
+	devices, err = kiz.GetDevicesByText(`room="Ch Lior"`)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 3)
+
+	_, err = kiz.GetDevicesByText("class=Heater")
+	assert.Error(t, err)
+
+	_, err = kiz.GetDevicesByText("bogus label")
+	assert.EqualError(t, err, "No device with that label")
+}
+
+func TestDeviceFromListByLabelSuggestions(t *testing.T) {
+	setup := helperLoadSetup(t)
+
+	_, err := DeviceFromListByLabel("volet nls", setup.Devices)
+	labelErr, ok := err.(*LabelError)
+	assert.True(t, ok)
+	assert.False(t, labelErr.Ambiguous)
+	assert.Equal(t, "Volet Nils", labelErr.Candidates[0].Label)
+
+	// accents and case are ignored, prefixes rank first
+	_, err = DeviceFromListByLabel("FENÊTRE LIT", setup.Devices)
+	assert.Equal(t, "Fenetre lit parents", err.(*LabelError).Candidates[0].Label)
+
+	_, err = DeviceFromListByLabel("garage door", setup.Devices)
+	assert.Empty(t, err.(*LabelError).Candidates)
+
+	devices := append(setup.Devices, Device{Label: "Volet Nils", DeviceURL: "io://1111-0000-4444/1"})
+	_, err = DeviceFromListByLabel("volet nils", devices)
+	assert.EqualError(t, err, "More than one device with that label")
+	assert.True(t, err.(*LabelError).Ambiguous)
+	assert.Len(t, err.(*LabelError).Candidates, 2)
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("spot", "spot"))
+	assert.Equal(t, 1, levenshtein("kitchn light", "kitchen light"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+	assert.Equal(t, 4, levenshtein("", "abcd"))
+}
+
+func TestGetSetup(t *testing.T) {
+	setup := helperLoadSetup(t)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/setup", req.URL.String())
+		json.NewEncoder(rw).Encode(setup)
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	s, err := kiz.GetSetup()
+	assert.NoError(t, err)
+	assert.Len(t, s.Devices, 19)
+	assert.Equal(t, "Europe/London", s.Location.Timezone)
+	place, ok := s.RootPlace.Find("ch parents")
+	assert.True(t, ok)
+	assert.True(t, s.RootPlace.Contains(place.OID))
+}
+
+func TestGetCurrentExecutions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/exec/current", req.URL.String())
+		rw.Write([]byte(`[{"id": "133a5c55-3655-5455-2355-c33e43535e55", "state": "IN_PROGRESS",
+			"actionGroup": {"label": "Spot", "actions": [{"deviceURL": "io://1111-0000-4444/11111111",
+			"commands": [{"name": "on"}]}]}}]`))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	executions, err := kiz.GetCurrentExecutions()
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+	assert.Equal(t, "Spot", executions[0].ActionGroup.Label)
+}
+
+func TestOutputFormats(t *testing.T) {
+	var devices []Device
+	assert.NoError(t, json.Unmarshal(helperLoadBytes(t, "getDevices.json"), &devices))
+	var tests = []struct {
+		format string
+		obj    interface{}
+		want   []string
+	}{
+		{"table", devices, []string{"LABEL ", "UICLASS", "Fenetre1  ", "io://1111-0000-4444/11784413"}},
+		{"table=Label,core:OpenClosedState", devices, []string{"CORE:OPENCLOSEDSTATE", "Fenetre1       closed"}},
+		{"wide", devices[2], []string{"CONTROLLABLENAME", "io:WindowOpenerVeluxIOComponent", "core:OpenClosedState=closed"}},
+		{"csv=Label,Definition.UIClass", devices[:2], []string{"LABEL,DEFINITION.UICLASS\nAlarm,Alarm\nActive button,Pod\n"}},
+		{"template={{.Label}} {{.DeviceURL}}", devices[2:4], []string{"Fenetre1 io://1111-0000-4444/11784413\nVolet1 "}},
+		{"jsonpath=$.Definition.Commands[0].commandName", devices[2:3], []string{"close\n"}},
+		{"jsonpath={.value}", []DeviceState{{Name: "core:OnOffState", Value: "on"}}, []string{"on\n"}},
+		{"jsonpath={.states[*].value}", devices[2], []string{"available\n", "closed\n"}},
+		{"table", []Event{&ExecutionStateChangedEvent{
+			GenericEvent:   GenericEvent{Name: "ExecutionStateChangedEvent"},
+			ExecutionEvent: ExecutionEvent{ExecID: "abc"},
+			OldState:       "INITIALIZED",
+			NewState:       "IN_PROGRESS",
+		}}, []string{"SUMMARY", "ExecutionStateChangedEvent", "abc INITIALIZED->IN_PROGRESS"}},
+		{"csv", []ActionGroup{{Label: "Morning", OID: "1234", Actions: []Action{{}, {}}}}, []string{"Morning,1234,2\n"}},
+		{"table", []Execution{{ID: "abc", State: "IN_PROGRESS", ActionGroup: ActionGroup{Label: "Spot"}}}, []string{"abc  Spot"}},
+		{"table", helperLoadSetup(t), []string{"SETUP-1111-0000-4444", "Europe/London"}},
+		{"csv", StateChanges(&DeviceStateChangedEvent{
+			GenericEvent: GenericEvent{Timestamp: 1574106269793},
+			DeviceURL:    "io://1111-0000-4444/11111111",
+			DeviceStates: []DeviceState{{Name: "core:OnOffState", Value: "on"}},
+		}), []string{"TIME,DEVICEURL,NAME,VALUE\n", ",io://1111-0000-4444/11111111,core:OnOffState,on\n"}},
+		{"csv", []struct {
+			Name   string
+			Closed bool
+			secret string
+		}{{"Volet1", true, "x"}}, []string{"NAME,CLOSED\nVolet1,true\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b strings.Builder
+			assert.NoError(t, Output(&b, tt.format, tt.obj))
+			for _, want := range tt.want {
+				assert.Contains(t, b.String(), want)
+			}
+		})
+	}
+	assert.Error(t, Output(ioutil.Discard, "bogus", devices))
+	assert.Error(t, Output(ioutil.Discard, "template={{.Label", devices))
+	assert.Error(t, Output(ioutil.Discard, "jsonpath=$.a[0", devices))
+}
+
+func TestPrinter(t *testing.T) {
+	events := []Event{
+		&ExecutionStateChangedEvent{
+			GenericEvent:   GenericEvent{Name: "ExecutionStateChangedEvent"},
+			ExecutionEvent: ExecutionEvent{ExecID: "abc"},
+		},
+		&DeviceStateChangedEvent{
+			GenericEvent: GenericEvent{Name: "DeviceStateChangedEvent"},
+			DeviceURL:    "io://1111-0000-4444/11111111",
+		},
+	}
+	var b strings.Builder
+	p := NewPrinter(&b, "csv=Name,DeviceURL")
+	for _, e := range events {
+		assert.NoError(t, p.Print(e))
+	}
+	assert.Equal(t, "NAME,DEVICEURL\nExecutionStateChangedEvent,\nDeviceStateChangedEvent,io://1111-0000-4444/11111111\n", b.String())
+
+	b.Reset()
+	p = NewPrinter(&b, "table=Name,DeviceURL")
+	for _, e := range events {
+		assert.NoError(t, p.Print(e))
+	}
+	assert.Equal(t, "NAME                        DEVICEURL\n"+
+		"ExecutionStateChangedEvent  \n"+
+		"DeviceStateChangedEvent     io://1111-0000-4444/11111111\n", b.String())
+}
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// helperGolden compares the text output of obj with a golden file, or updates it with -update
+func helperGolden(t *testing.T, name string, obj interface{}) {
+	var b strings.Builder
+	assert.NoError(t, Output(&b, "text", obj))
+	path := filepath.Join("testdata", "golden", name+".txt")
+	if *update {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(b.String()), 0644))
+	}
+	want, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(want), b.String())
+}
+
+func TestOutputTextGolden(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	var devices []Device
+	assert.NoError(t, json.Unmarshal(helperLoadBytes(t, "getDevices.json"), &devices))
+	var actionGroups []ActionGroup
+	assert.NoError(t, json.Unmarshal(helperLoadBytes(t, "getActionGroups.json"), &actionGroups))
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write(helperLoadBytes(t, "pollEvents.json"))
+	}))
+	defer server.Close()
+	events, err := getTestKiz(t, server).PollEvents()
+	assert.NoError(t, err)
+	executions := []Execution{{
+		ID:          "133a5c55-3655-5455-2355-c33e43535e55",
+		State:       "IN_PROGRESS",
+		Owner:       "user@example.com",
+		StartTime:   1574106269793,
+		ActionGroup: actionGroups[0],
+	}}
+
+	helperGolden(t, "devices", devices)
+	helperGolden(t, "states", devices[2].States)
+	helperGolden(t, "actiongroups", actionGroups)
+	helperGolden(t, "events", events)
+	helperGolden(t, "executions", executions)
+	helperGolden(t, "setup", helperLoadSetup(t))
+
+	closure, intensity, on := 40, 70, true
+	helperGolden(t, "snapshots", []Snapshot{{
+		Name: "evening",
+		Time: time.Unix(1574106269, 0),
+		Devices: []DeviceSnapshot{
+			{DeviceURL: "io://1111-0000-4444/22222222", Label: "Volet1", Closure: &closure},
+			{DeviceURL: "io://1111-0000-4444/13523721", Label: "Spots Nils", Intensity: &intensity, On: &on},
+		},
+	}})
+}
+
+func TestStateCache(t *testing.T) {
+	setup := helperLoadSetup(t)
+	cache := NewStateCache(setup)
+	url := setup.Devices[2].DeviceURL
+	before, ok := cache.Device(url)
+	assert.True(t, ok)
+
+	changed := cache.Update(&DeviceStateChangedEvent{
+		DeviceURL:    url,
+		DeviceStates: []DeviceState{{Name: "core:ClosureState", Type: StateInt, Value: 42}, {Name: "core:NewState", Type: StateString, Value: "x"}},
+	})
+	assert.True(t, changed)
+	s, ok := cache.State(url, "core:ClosureState")
+	assert.True(t, ok)
+	assert.Equal(t, 42, s.Value)
+	_, ok = cache.State(url, "core:NewState")
+	assert.True(t, ok)
+	for _, s := range before.States {
+		assert.NotEqual(t, StateName("core:NewState"), s.Name, "devices returned earlier must not change")
+	}
+
+	assert.True(t, cache.Update(&DeviceUnavailableEvent{DeviceAvailabilityEvent: DeviceAvailabilityEvent{DeviceURL: url}}))
+	d, _ := cache.Device(url)
+	assert.False(t, d.Available)
+	assert.False(t, cache.Update(&DeviceAvailableEvent{DeviceAvailabilityEvent: DeviceAvailabilityEvent{DeviceURL: "io://unknown"}}))
+
+	gateway := setup.Gateways[0].GatewayID
+	assert.True(t, cache.Gateways()[gateway])
+	cache.Update(&GatewayDownEvent{GatewayEvent: GatewayEvent{GatewayID: gateway}})
+	assert.False(t, cache.Gateways()[gateway])
+	assert.Len(t, cache.Devices(), len(setup.Devices))
+}
+
+func TestExecuteActionGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "/enduserAPI/exec/21cd8954-95ea-4636-bfac-ec149982906c", req.URL.String())
+		rw.Write([]byte(`{"execId": "133a5c55-3655-5455-2355-c33e43535e55"}`))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	id, err := kiz.ExecuteActionGroup("21cd8954-95ea-4636-bfac-ec149982906c")
+	assert.NoError(t, err)
+	assert.Equal(t, ExecID("133a5c55-3655-5455-2355-c33e43535e55"), id)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	devices := helperLoadSetup(t).Devices
+	for i, d := range devices {
+		switch d.Label {
+		case "Volet1":
+			devices[i].States = []DeviceState{{Name: StateClosure, Type: StateInt, Value: 40}}
+		case "Spots Nils":
+			devices[i].States = []DeviceState{
+				{Name: StateLightIntensity, Type: StateInt, Value: 70},
+				{Name: StateOnOff, Type: StateString, Value: "on"},
+			}
+		}
+	}
+	var executed ActionGroup
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/enduserAPI/setup/devices":
+			assert.NoError(t, json.NewEncoder(rw).Encode(devices))
+		case "/enduserAPI/exec/apply":
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&executed))
+			rw.Write([]byte(`{"execId": "133a5c55-3655-5455-2355-c33e43535e55"}`))
+		default:
+			t.Errorf("Unexpected request %s", req.URL)
+		}
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+
+	selected, err := DevicesFromSetupByText("label=Volet1 or label=\"Spots Nils\" or label=Pod", helperLoadSetup(t))
+	assert.NoError(t, err)
+	snapshot, err := kiz.Snapshot(selected)
+	assert.NoError(t, err)
+	if assert.Len(t, snapshot.Devices, 2) {
+		assert.Equal(t, 40, *snapshot.Devices[0].Closure)
+		assert.Equal(t, 70, *snapshot.Devices[1].Intensity)
+		assert.True(t, *snapshot.Devices[1].On)
+	}
+
+	snapshot.Name = "evening"
+	id, err := kiz.Restore(snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, ExecID("133a5c55-3655-5455-2355-c33e43535e55"), id)
+	assert.Equal(t, "Restore evening", executed.Label)
+	if assert.Len(t, executed.Actions, 2) {
+		assert.Equal(t, CmdSetClosure, executed.Actions[0].Commands[0].Name)
+		assert.Equal(t, []interface{}{float64(40)}, executed.Actions[0].Commands[0].Parameters)
+		assert.Equal(t, CmdSetIntensity, executed.Actions[1].Commands[0].Name)
+		assert.Equal(t, []interface{}{float64(70)}, executed.Actions[1].Commands[0].Parameters)
+	}
+
+	_, err = RestoreActionGroup(snapshot, devices[:1])
+	assert.Error(t, err)
+}
+
+func TestRestoreCommand(t *testing.T) {
+	light := Device{Definition: DeviceDefinition{Commands: []CommandDefinition{{CommandName: CmdOn}, {CommandName: CmdOff}}}}
+	on, off, level := true, false, 30
+	c, ok := RestoreCommand(light, DeviceSnapshot{On: &off, Intensity: &level})
+	assert.True(t, ok)
+	assert.Equal(t, CmdOff, c.Name)
+	c, ok = RestoreCommand(light, DeviceSnapshot{On: &on, Intensity: &level})
+	assert.True(t, ok)
+	assert.Equal(t, CmdOn, c.Name)
+	_, ok = RestoreCommand(light, DeviceSnapshot{Closure: &level})
+	assert.False(t, ok)
+}
+
+func TestGroups(t *testing.T) {
+	setup := helperLoadSetup(t)
+	groups := Groups{
+		"parents":  {"Volet lit parents", "Fenetre lit parents"},
+		"nils":     {"Volet Nils", "io://1111-0000-4444/16335438"},
+		"upstairs": {"@parents", "@Nils", "Volet lit parents"},
+	}
+	assert.NoError(t, groups.Validate(setup))
+	devices, err := groups.Devices("@upstairs", setup)
+	assert.NoError(t, err)
+	var labels []string
+	for _, d := range devices {
+		labels = append(labels, d.Label)
+	}
+	assert.Equal(t, []string{"Volet lit parents", "Fenetre lit parents", "Volet Nils", "Fenetre Nils"}, labels)
+	assert.Equal(t, "nils", groups.List()[0].Name)
+
+	_, err = groups.Devices("nope", setup)
+	assert.Error(t, err)
+
+	groups["parents"] = append(groups["parents"], "Volet disparu")
+	groups["loop"] = []string{"@upstairs", "@loop"}
+	err = groups.Validate(setup)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Volet disparu")
+		assert.Contains(t, err.Error(), "loop contains itself: loop > loop")
+		assert.Equal(t, 1, strings.Count(err.Error(), "Volet disparu"))
+	}
+}
+
+func TestGetDevicesByGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/setup", req.URL.Path)
+		assert.NoError(t, json.NewEncoder(rw).Encode(helperLoadSetup(t)))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	kiz.SetGroups(Groups{"volets": {"Volet1", "Volet Nils"}})
+	devices, err := kiz.GetDevicesByText("@volets")
+	assert.NoError(t, err)
+	assert.Len(t, devices, 2)
+	_, err = kiz.GetDevicesByText("@other")
+	assert.Error(t, err)
+}
+
+// fakeStates is a StateSource returning a fixed setup
+type fakeStates struct {
+	setup Setup
+	calls int
+}
+
+func (f *fakeStates) GetSetup() (Setup, error) {
+	f.calls++
+	return f.setup, nil
+}
+
+// setState sets the value of a state of the labelled device
+func setState(setup Setup, label string, name StateName, value interface{}) {
+	for i, d := range setup.Devices {
+		if d.Label != label {
+			continue
+		}
+		for j, s := range d.States {
+			if s.Name == name {
+				setup.Devices[i].States[j].Value = value
+				return
+			}
+		}
+		setup.Devices[i].States = append(d.States, DeviceState{Name: name, Value: value})
+	}
+}
+
+func TestGuard(t *testing.T) {
+	setup := helperLoadSetup(t)
+	states := &fakeStates{setup: setup}
+	min, max := 10.0, 80.0
+	guard, err := NewGuard([]Policy{
+		{
+			Name:       "rain",
+			Devices:    "@roof",
+			Commands:   []string{CmdOpen, CmdSetClosure},
+			RefuseWhen: &PolicyCondition{Device: "Fenetre Lior", State: "core:RainState", Value: "detected"},
+		},
+		{
+			Name:       "window open",
+			Devices:    "Volet Nils",
+			Commands:   []string{CmdClose, CmdSetClosure},
+			RefuseWhen: &PolicyCondition{Device: "Fenetre Nils", State: "core:OpenClosedState", Value: "open"},
+		},
+		{Devices: "class=Light", Commands: []string{CmdSetIntensity}, Min: &min, Max: &max},
+	}, states, Groups{"roof": {"Fenetre Lior"}})
+	assert.NoError(t, err)
+
+	url := func(label string) DeviceURL {
+		devices, err := DevicesFromSetupByText(label, setup)
+		assert.NoError(t, err)
+		return devices[0].DeviceURL
+	}
+	ag := func(label string, c Command) ActionGroup {
+		return ActionGroup{Actions: []Action{{DeviceURL: url(label), Commands: []Command{c}}}}
+	}
+
+	assert.NoError(t, guard.Check(ag("Fenetre Lior", Command{Name: CmdOpen})))
+	setState(setup, "Fenetre Lior", "core:RainState", "detected")
+	err = guard.Check(ag("Fenetre Lior", Command{Name: CmdOpen}))
+	if assert.IsType(t, &GuardError{}, err) {
+		assert.Equal(t, "rain", err.(*GuardError).Policy)
+		assert.Contains(t, err.Error(), "Fenetre Lior core:RainState is detected")
+	}
+	assert.NoError(t, guard.Check(ag("Fenetre Lior", Command{Name: CmdClose})))
+
+	assert.NoError(t, guard.Check(ag("Volet Nils", Command{Name: CmdSetClosure, Parameters: []int{100}})))
+	setState(setup, "Fenetre Nils", "core:OpenClosedState", "open")
+	err = guard.Check(ag("Volet Nils", Command{Name: CmdSetClosure, Parameters: []int{100}}))
+	assert.IsType(t, &GuardError{}, err)
+	assert.NoError(t, guard.Check(ag("Volet1", Command{Name: CmdClose})))
+
+	assert.NoError(t, guard.Check(ag("Spots Nils", Command{Name: CmdSetIntensity, Parameters: []int{50}})))
+	err = guard.Check(ag("Spots Nils", Command{Name: CmdSetIntensity, Parameters: []interface{}{95}}))
+	if assert.IsType(t, &GuardError{}, err) {
+		assert.Equal(t, "policy 3", err.(*GuardError).Policy)
+		assert.Contains(t, err.Error(), "above the maximum 80")
+	}
+	err = guard.Check(ag("Spots Nils", Command{Name: CmdSetIntensity, Parameters: []int{5}}))
+	assert.IsType(t, &GuardError{}, err)
+
+	_, err = NewGuard([]Policy{{Name: "empty"}}, states, nil)
+	assert.Error(t, err)
+	_, err = NewGuard([]Policy{{RefuseWhen: &PolicyCondition{Device: "x", State: "y", Op: "<", Value: "z"}}}, states, nil)
+	assert.Error(t, err)
+}
+
+func TestExecuteGuarded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Errorf("Unexpected request %s", req.URL)
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	setup := helperLoadSetup(t)
+	max := 0.0
+	guard, err := NewGuard([]Policy{{Max: &max}}, &fakeStates{setup: setup}, nil)
+	assert.NoError(t, err)
+	kiz.SetGuard(guard)
+	_, err = kiz.Execute(ActionGroup{Actions: []Action{{
+		DeviceURL: setup.Devices[0].DeviceURL,
+		Commands:  []Command{{Name: CmdSetClosure, Parameters: []int{50}}},
+	}}})
+	assert.IsType(t, &GuardError{}, err)
+}
+
+func TestExecuteActionGroupGuarded(t *testing.T) {
+	setup := helperLoadSetup(t)
+	ags, err := json.Marshal([]ActionGroup{{OID: "1234", Label: "Half", Actions: []Action{{
+		DeviceURL: setup.Devices[0].DeviceURL,
+		Commands:  []Command{{Name: CmdSetClosure, Parameters: []int{50}}},
+	}}}})
+	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/enduserAPI/actionGroups" {
+			t.Errorf("Unexpected request %s", req.URL)
+		}
+		rw.Write(ags)
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	max := 0.0
+	guard, err := NewGuard([]Policy{{Max: &max}}, &fakeStates{setup: setup}, nil)
+	assert.NoError(t, err)
+	kiz.SetGuard(guard)
+	_, err = kiz.ExecuteActionGroup("1234")
+	assert.IsType(t, &GuardError{}, err)
+	_, err = kiz.ExecuteActionGroup("5678")
+	assert.Error(t, err)
+}
+
+func TestExecuteDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/setup/devices", req.URL.Path)
+		rw.Write(helperLoadBytes(t, "getDevices.json"))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	var b strings.Builder
+	kiz.SetDryRun(&b)
+
+	ag := ActionGroup{Actions: []Action{{
+		DeviceURL: "io://1111-0000-4444/22222222",
+		Commands:  []Command{{Name: CmdSetClosure, Parameters: []int{30}}},
+	}}}
+	id, err := kiz.Execute(ag)
+	assert.NoError(t, err)
+	assert.Equal(t, ExecID(""), id)
+	var printed ActionGroup
+	assert.NoError(t, json.Unmarshal([]byte(b.String()), &printed))
+	assert.Equal(t, "io://1111-0000-4444/22222222", string(printed.Actions[0].DeviceURL))
+	assert.Equal(t, []interface{}{float64(30)}, printed.Actions[0].Commands[0].Parameters)
+
+	_, err = kiz.ExecuteActionGroup("1234")
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "Would execute action group 1234")
+
+	ag.Actions[0].Commands[0].Parameters = nil
+	_, err = kiz.Execute(ag)
+	assert.EqualError(t, err, "Command setClosure of device Volet1 expects 1 parameters, got 0")
+	ag.Actions[0].Commands[0].Name = CmdSetIntensity
+	_, err = kiz.Execute(ag)
+	assert.EqualError(t, err, "Device Volet1 does not support command setIntensity")
+	ag.Actions[0].DeviceURL = "io://1111-0000-4444/0"
+	_, err = kiz.Execute(ag)
+	assert.Error(t, err)
+}
+
+func TestRefreshDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/enduserAPI/setup/devices", req.URL.Path)
+		rw.Write(helperLoadBytes(t, "getDevices.json"))
+	}))
+	defer server.Close()
+	kiz := getTestKiz(t, server)
+	var b strings.Builder
+	kiz.SetDryRun(&b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	device := Device{
+		DeviceURL: "internal://1111-0000-4444/alarm/0",
+		Definition: DeviceDefinition{
+			Commands: []CommandDefinition{{CommandName: "refreshCurrentAlarmMode"}},
+		},
+	}
+	assert.NoError(t, kiz.RefreshDeviceStates(ctx, device))
+	assert.Contains(t, b.String(), "refreshCurrentAlarmMode")
+
+	assert.NoError(t, kiz.RefreshStates())
+	assert.Contains(t, b.String(), "Would refresh the states of all devices")
+}
@@ -0,0 +1,502 @@
+// Package kizcooltest provides an in-memory fake of kizcool.Client recording its calls, to unit test
+// code depending on the kizcool.Client interface without any server. Use package kiztest instead
+// to test the http layer.
+//
+//	fake := kizcooltest.New(devices...)
+//	err := closeAll(fake)
+//	for _, ag := range fake.Executed() { ... }
+package kizcooltest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+	"github.com/sgrimee/kizcool/kiztest"
+)
+
+// SetupOID is the OID of the setup served by a new fake
+const SetupOID = kiztest.SetupOID
+
+// Call is a recorded method call with its arguments
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Fake implements kizcool.Client in memory. Executions complete at once: commands update the
+// device states as a box would and the resulting events are returned by PollEvents.
+// All calls are recorded, and errors can be set per method. It is safe for concurrent use.
+type Fake struct {
+	mux          sync.Mutex
+	setup        kizcool.Setup
+	groups       kizcool.Groups
+	actionGroups []kizcool.ActionGroup
+	errors       map[string]error
+	calls        []Call
+	executed     []kizcool.ActionGroup
+	events       kizcool.Events
+	execCount    int
+	// changed is closed and replaced when states change or events are queued
+	changed chan struct{}
+}
+
+var _ kizcool.Client = (*Fake)(nil)
+
+// New returns a fake serving the devices
+func New(devices ...kizcool.Device) *Fake {
+	return &Fake{
+		setup:   kizcool.Setup{OID: SetupOID, Devices: devices},
+		errors:  make(map[string]error),
+		changed: make(chan struct{}),
+	}
+}
+
+// SetSetup replaces the setup and its devices
+func (f *Fake) SetSetup(setup kizcool.Setup) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.setup = setup
+	f.notify()
+}
+
+// SetGroups sets the device groups that can be designated with @name in device texts
+func (f *Fake) SetGroups(groups kizcool.Groups) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.groups = groups
+}
+
+// SetActionGroups sets the action groups (scenarios) stored on the fake box
+func (f *Fake) SetActionGroups(ags []kizcool.ActionGroup) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.actionGroups = ags
+}
+
+// SetError makes the method with the given name, e.g. "Execute", fail with err. A nil err removes the error.
+// Failing calls are recorded and have no effect.
+func (f *Fake) SetError(method string, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	f.errors[method] = err
+}
+
+// SetState sets the value of a device state, adding the state if missing, and queues the change event
+func (f *Fake) SetState(url kizcool.DeviceURL, name kizcool.StateName, value interface{}) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	d := f.device(url)
+	if d == nil {
+		return fmt.Errorf("No device with URL %s", url)
+	}
+	f.setStates(d, []kizcool.DeviceState{{Name: name, Type: kiztest.StateType(value), Value: value}})
+	return nil
+}
+
+// Emit queues the events for PollEvents
+func (f *Fake) Emit(events ...kizcool.Event) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.events = append(f.events, events...)
+	f.notify()
+}
+
+// Calls returns the calls made so far, in order
+func (f *Fake) Calls() []Call {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls made so far to the method with the given name
+func (f *Fake) CallsTo(method string) []Call {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	var calls []Call
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Executed returns the action groups executed so far, whatever the method used, e.g. Execute or Open
+func (f *Fake) Executed() []kizcool.ActionGroup {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]kizcool.ActionGroup(nil), f.executed...)
+}
+
+// Reset forgets the calls and executions recorded so far
+func (f *Fake) Reset() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = nil
+	f.executed = nil
+}
+
+// GetSetup returns a copy of the setup
+func (f *Fake) GetSetup() (kizcool.Setup, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetSetup"); err != nil {
+		return kizcool.Setup{}, err
+	}
+	setup := f.setup
+	setup.Devices = f.devices()
+	return setup, nil
+}
+
+// GetDevices returns a copy of the devices
+func (f *Fake) GetDevices() ([]kizcool.Device, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetDevices"); err != nil {
+		return nil, err
+	}
+	return f.devices(), nil
+}
+
+// GetDevice returns a copy of a single device
+func (f *Fake) GetDevice(deviceURL kizcool.DeviceURL) (kizcool.Device, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetDevice", deviceURL); err != nil {
+		return kizcool.Device{}, err
+	}
+	d := f.device(deviceURL)
+	if d == nil {
+		return kizcool.Device{}, fmt.Errorf("No device with URL %s", deviceURL)
+	}
+	return copyDevice(*d), nil
+}
+
+// GetDeviceByText returns the device with the given URL or label
+func (f *Fake) GetDeviceByText(text string) (kizcool.Device, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetDeviceByText", text); err != nil {
+		return kizcool.Device{}, err
+	}
+	if d := f.device(kizcool.DeviceURL(text)); d != nil {
+		return copyDevice(*d), nil
+	}
+	return kizcool.DeviceFromListByLabel(text, f.devices())
+}
+
+// GetDevicesByText returns the devices designated by a URL, label, selector or @group, see kizcool.Kiz
+func (f *Fake) GetDevicesByText(text string) ([]kizcool.Device, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetDevicesByText", text); err != nil {
+		return nil, err
+	}
+	setup := f.setup
+	setup.Devices = f.devices()
+	if strings.HasPrefix(text, kizcool.GroupPrefix) {
+		return f.groups.Devices(text, setup)
+	}
+	return kizcool.DevicesFromSetupByText(text, setup)
+}
+
+// GetDeviceState returns the current value of a device state
+func (f *Fake) GetDeviceState(deviceURL kizcool.DeviceURL, stateName kizcool.StateName) (kizcool.DeviceState, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetDeviceState", deviceURL, stateName); err != nil {
+		return kizcool.DeviceState{}, err
+	}
+	return f.state(deviceURL, stateName)
+}
+
+// RefreshStates queues a state change event with all the states of each device
+func (f *Fake) RefreshStates() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("RefreshStates"); err != nil {
+		return err
+	}
+	for _, d := range f.setup.Devices {
+		if len(d.States) > 0 {
+			f.events = append(f.events, kiztest.StateEvent(f.setup.OID, d.DeviceURL, d.States))
+		}
+	}
+	f.notify()
+	return nil
+}
+
+// RefreshDeviceStates records the call, states are always up to date in the fake
+func (f *Fake) RefreshDeviceStates(ctx context.Context, device kizcool.Device, stateNames ...kizcool.StateName) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	args := []interface{}{device.DeviceURL}
+	for _, name := range stateNames {
+		args = append(args, name)
+	}
+	return f.record("RefreshDeviceStates", args...)
+}
+
+// WaitForState waits until the state of the device satisfies the predicate, or the context is done
+func (f *Fake) WaitForState(ctx context.Context, device kizcool.Device, stateName kizcool.StateName, predicate kizcool.StatePredicate) (kizcool.DeviceState, error) {
+	f.mux.Lock()
+	if err := f.record("WaitForState", device.DeviceURL, stateName); err != nil {
+		f.mux.Unlock()
+		return kizcool.DeviceState{}, err
+	}
+	for {
+		state, err := f.state(device.DeviceURL, stateName)
+		if err == nil && predicate(state) {
+			f.mux.Unlock()
+			return state, nil
+		}
+		changed := f.changed
+		f.mux.Unlock()
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-changed:
+		}
+		f.mux.Lock()
+	}
+}
+
+// Execute applies the commands of the action group to the device states
+func (f *Fake) Execute(ag kizcool.ActionGroup) (kizcool.ExecID, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("Execute", ag); err != nil {
+		return "", err
+	}
+	return f.execute(ag)
+}
+
+// GetCurrentExecutions returns no execution, they complete at once
+func (f *Fake) GetCurrentExecutions() ([]kizcool.Execution, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return nil, f.record("GetCurrentExecutions")
+}
+
+// On turns a device on
+func (f *Fake) On(device kizcool.Device) (kizcool.ExecID, error) {
+	return f.command("On", device, kizcool.Command{Name: kizcool.CmdOn})
+}
+
+// Off turns a device off
+func (f *Fake) Off(device kizcool.Device) (kizcool.ExecID, error) {
+	return f.command("Off", device, kizcool.Command{Name: kizcool.CmdOff})
+}
+
+// Open opens a device
+func (f *Fake) Open(device kizcool.Device) (kizcool.ExecID, error) {
+	return f.command("Open", device, kizcool.Command{Name: kizcool.CmdOpen})
+}
+
+// Close closes a device
+func (f *Fake) Close(device kizcool.Device) (kizcool.ExecID, error) {
+	return f.command("Close", device, kizcool.Command{Name: kizcool.CmdClose})
+}
+
+// Stop interrupts the current activity
+func (f *Fake) Stop(device kizcool.Device) (kizcool.ExecID, error) {
+	return f.command("Stop", device, kizcool.Command{Name: kizcool.CmdStop})
+}
+
+// SetIntensity sets the light intensity to given value
+func (f *Fake) SetIntensity(device kizcool.Device, intensity int) (kizcool.ExecID, error) {
+	return f.command("SetIntensity", device, kizcool.Command{Name: kizcool.CmdSetIntensity, Parameters: []int{intensity}}, intensity)
+}
+
+// SetClosure sets the device closure/position to given value
+func (f *Fake) SetClosure(device kizcool.Device, position int) (kizcool.ExecID, error) {
+	return f.command("SetClosure", device, kizcool.Command{Name: kizcool.CmdSetClosure, Parameters: []int{position}}, position)
+}
+
+// GetActionGroups returns the action groups set with SetActionGroups
+func (f *Fake) GetActionGroups() ([]kizcool.ActionGroup, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("GetActionGroups"); err != nil {
+		return nil, err
+	}
+	return append([]kizcool.ActionGroup(nil), f.actionGroups...), nil
+}
+
+// ExecuteActionGroup executes the action group set with SetActionGroups with the given OID
+func (f *Fake) ExecuteActionGroup(oid string) (kizcool.ExecID, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("ExecuteActionGroup", oid); err != nil {
+		return "", err
+	}
+	for _, ag := range f.actionGroups {
+		if ag.OID == oid {
+			return f.execute(ag)
+		}
+	}
+	return "", fmt.Errorf("No action group with OID %s", oid)
+}
+
+// PollEvents returns the events queued since the last poll
+func (f *Fake) PollEvents() (kizcool.Events, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record("PollEvents"); err != nil {
+		return nil, err
+	}
+	events := f.events
+	f.events = nil
+	return events, nil
+}
+
+// PollEventsContinuous sends the queued events as they come, until finish is closed
+func (f *Fake) PollEventsContinuous(ev chan<- kizcool.Event, e chan<- error, finish <-chan struct{}) {
+	f.mux.Lock()
+	f.record("PollEventsContinuous")
+	f.mux.Unlock()
+	for {
+		f.mux.Lock()
+		events, err := f.events, f.errors["PollEvents"]
+		if err == nil {
+			f.events = nil
+		}
+		changed := f.changed
+		f.mux.Unlock()
+		if err != nil {
+			select {
+			case e <- err:
+			case <-finish:
+				return
+			}
+		}
+		for _, event := range events {
+			select {
+			case ev <- event:
+			case <-finish:
+				return
+			}
+		}
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-finish:
+			return
+		}
+	}
+}
+
+// Stats returns the number of calls made to the fake
+func (f *Fake) Stats() api.Stats {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return api.Stats{Calls: len(f.calls)}
+}
+
+// record appends the call and returns the error set for the method. The lock must be held.
+func (f *Fake) record(method string, args ...interface{}) error {
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+	return f.errors[method]
+}
+
+// command records the call and executes a single command on the device
+func (f *Fake) command(method string, device kizcool.Device, c kizcool.Command, args ...interface{}) (kizcool.ExecID, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.record(method, append([]interface{}{device.DeviceURL}, args...)...); err != nil {
+		return "", err
+	}
+	ag, err := kizcool.ActionGroupWithOneCommand(device, c)
+	if err != nil {
+		return "", err
+	}
+	return f.execute(ag)
+}
+
+// execute applies the action group and queues the events of the execution. The lock must be held.
+func (f *Fake) execute(ag kizcool.ActionGroup) (kizcool.ExecID, error) {
+	for _, a := range ag.Actions {
+		if f.device(a.DeviceURL) == nil {
+			return "", fmt.Errorf("Invalid device URL %s", a.DeviceURL)
+		}
+	}
+	f.execCount++
+	id := kizcool.ExecID(fmt.Sprintf("exec-%d", f.execCount))
+	exec := kizcool.ExecutionEvent{ExecID: id, SetupOID: f.setup.OID}
+	f.events = append(f.events, &kizcool.ExecutionRegisteredEvent{GenericEvent: kiztest.NewGenericEvent("ExecutionRegisteredEvent"),
+		ExecutionEvent: exec, Label: ag.Label, Actions: ag.Actions})
+	for _, a := range ag.Actions {
+		d := f.device(a.DeviceURL)
+		for _, c := range a.Commands {
+			f.setStates(d, kiztest.CommandStates(*d, c))
+		}
+	}
+	f.events = append(f.events, &kizcool.ExecutionStateChangedEvent{GenericEvent: kiztest.NewGenericEvent("ExecutionStateChangedEvent"),
+		ExecutionEvent: exec, OldState: "IN_PROGRESS", NewState: "COMPLETED"})
+	f.executed = append(f.executed, ag)
+	f.notify()
+	return id, nil
+}
+
+// setStates updates or adds the states of the device and queues an event for those that changed.
+// The lock must be held.
+func (f *Fake) setStates(d *kizcool.Device, states []kizcool.DeviceState) {
+	if changed := kiztest.AddStates(d, states); len(changed) > 0 {
+		f.events = append(f.events, kiztest.StateEvent(f.setup.OID, d.DeviceURL, changed))
+		f.notify()
+	}
+}
+
+// state returns a state of a device. The lock must be held.
+func (f *Fake) state(url kizcool.DeviceURL, name kizcool.StateName) (kizcool.DeviceState, error) {
+	d := f.device(url)
+	if d == nil {
+		return kizcool.DeviceState{}, fmt.Errorf("No device with URL %s", url)
+	}
+	for _, st := range d.States {
+		if st.Name == name {
+			return st, nil
+		}
+	}
+	return kizcool.DeviceState{}, fmt.Errorf("No state %s for device %s", name, url)
+}
+
+// notify wakes up the goroutines waiting for a change. The lock must be held.
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// device returns the device with the given url, or nil. The lock must be held.
+func (f *Fake) device(url kizcool.DeviceURL) *kizcool.Device {
+	for i := range f.setup.Devices {
+		if f.setup.Devices[i].DeviceURL == url {
+			return &f.setup.Devices[i]
+		}
+	}
+	return nil
+}
+
+// devices returns a copy of the devices. The lock must be held.
+func (f *Fake) devices() []kizcool.Device {
+	devices := make([]kizcool.Device, len(f.setup.Devices))
+	for i, d := range f.setup.Devices {
+		devices[i] = copyDevice(d)
+	}
+	return devices
+}
+
+// copyDevice returns the device with its own copy of the states
+func copyDevice(d kizcool.Device) kizcool.Device {
+	d.States = append([]kizcool.DeviceState(nil), d.States...)
+	return d
+}
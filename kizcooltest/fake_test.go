@@ -0,0 +1,101 @@
+package kizcooltest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/stretchr/testify/assert"
+)
+
+const volet1 = kizcool.DeviceURL("io://1111-0000-4444/22222222")
+
+func helperFake(t *testing.T) *Fake {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "getDevices.json"))
+	assert.NoError(t, err)
+	var devices []kizcool.Device
+	assert.NoError(t, json.Unmarshal(data, &devices))
+	return New(devices...)
+}
+
+func TestFakeExecute(t *testing.T) {
+	f := helperFake(t)
+	device, err := f.GetDeviceByText("Volet1")
+	assert.NoError(t, err)
+	_, err = f.Close(device)
+	assert.NoError(t, err)
+
+	state, err := f.GetDeviceState(volet1, kizcool.StateClosure)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, state.Value)
+	if assert.Len(t, f.Executed(), 1) {
+		assert.Equal(t, kizcool.CmdClose, f.Executed()[0].Actions[0].Commands[0].Name)
+	}
+	assert.Equal(t, []Call{{Method: "Close", Args: []interface{}{volet1}}}, f.CallsTo("Close"))
+	assert.Equal(t, "GetDeviceByText", f.Calls()[0].Method)
+
+	events, err := f.PollEvents()
+	assert.NoError(t, err)
+	var completed bool
+	for _, e := range events {
+		if esce, ok := e.(*kizcool.ExecutionStateChangedEvent); ok {
+			completed = esce.NewState == "COMPLETED"
+		}
+	}
+	assert.True(t, completed)
+	events, err = f.PollEvents()
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestFakeSetError(t *testing.T) {
+	f := helperFake(t)
+	device, err := f.GetDevice(volet1)
+	assert.NoError(t, err)
+	boom := errors.New("boom")
+	f.SetError("Execute", boom)
+	_, err = f.Execute(kizcool.ActionGroup{Actions: []kizcool.Action{{DeviceURL: volet1, Commands: []kizcool.Command{{Name: kizcool.CmdOpen}}}}})
+	assert.Equal(t, boom, err)
+	assert.Empty(t, f.Executed())
+	assert.Len(t, f.CallsTo("Execute"), 1)
+
+	f.SetError("Execute", nil)
+	_, err = f.Open(device)
+	assert.NoError(t, err, "only Execute fails")
+	_, err = f.Execute(kizcool.ActionGroup{Actions: []kizcool.Action{{DeviceURL: "io://0000-0000-0000/1"}}})
+	assert.Error(t, err)
+}
+
+func TestFakeWaitForState(t *testing.T) {
+	f := helperFake(t)
+	device, err := f.GetDevice(volet1)
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.SetState(volet1, kizcool.StateClosure, 42)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	state, err := f.WaitForState(ctx, device, kizcool.StateClosure, func(s kizcool.DeviceState) bool { return s.Value == 42 })
+	assert.NoError(t, err)
+	assert.Equal(t, 42, state.Value)
+}
+
+func TestFakeActionGroups(t *testing.T) {
+	f := helperFake(t)
+	f.SetActionGroups([]kizcool.ActionGroup{{OID: "ag1", Label: "Morning", Actions: []kizcool.Action{
+		{DeviceURL: volet1, Commands: []kizcool.Command{{Name: kizcool.CmdSetClosure, Parameters: []int{30}}}},
+	}}})
+	_, err := f.ExecuteActionGroup("ag1")
+	assert.NoError(t, err)
+	state, err := f.GetDeviceState(volet1, kizcool.StateClosure)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, state.Value)
+	_, err = f.ExecuteActionGroup("unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package kiztest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// Executor records the executed action groups and scenarios without running them,
+// e.g. to test the rules engine or the scheduler. It is safe for concurrent use.
+type Executor struct {
+	mux       sync.Mutex
+	executed  []kizcool.ActionGroup
+	scenarios []string
+}
+
+// Execute records the action group
+func (e *Executor) Execute(ag kizcool.ActionGroup) (kizcool.ExecID, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.executed = append(e.executed, ag)
+	return "id", nil
+}
+
+// ExecuteActionGroup records the OID of the scenario
+func (e *Executor) ExecuteActionGroup(oid string) (kizcool.ExecID, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.scenarios = append(e.scenarios, oid)
+	return "id", nil
+}
+
+// Executed returns the executed action groups, in order
+func (e *Executor) Executed() []kizcool.ActionGroup {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return append([]kizcool.ActionGroup(nil), e.executed...)
+}
+
+// Scenarios returns the OIDs of the executed scenarios, in order
+func (e *Executor) Scenarios() []string {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return append([]string(nil), e.scenarios...)
+}
+
+// LoadSetup reads a setup fixture, see ReadSetupFile, and fails the test on error
+func LoadSetup(t testing.TB, path string) kizcool.Setup {
+	t.Helper()
+	setup, err := ReadSetupFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return setup
+}
@@ -0,0 +1,99 @@
+package kiztest
+
+import (
+	"time"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// movement is a closure change in progress
+type movement struct {
+	target int
+	execID kizcool.ExecID
+}
+
+// SetMotion makes closures change progressively, like motors, taking travel to go from 0 to 100%
+// with a DeviceStateChangedEvent every step. Executions remain in progress until all their
+// devices reach their target, and the stop command interrupts a movement.
+// A travel of 0, the default, applies commands at once.
+func (s *Server) SetMotion(travel, step time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.travel, s.step = travel, step
+}
+
+// closureTarget returns the closure the states lead to, if the device has a numeric closure
+func closureTarget(d kizcool.Device, states []kizcool.DeviceState) (int, bool) {
+	if _, ok := closure(d); !ok {
+		return 0, false
+	}
+	for _, st := range states {
+		if st.Name == kizcool.StateClosure {
+			return st.Value.(int), true
+		}
+	}
+	return 0, false
+}
+
+// closure returns the current closure of the device
+func closure(d kizcool.Device) (float64, bool) {
+	for _, st := range d.States {
+		if st.Name == kizcool.StateClosure {
+			return st.Float()
+		}
+	}
+	return 0, false
+}
+
+// move steps the closures of the devices of the execution until they reach their target,
+// are stopped or are taken over by another execution
+func (s *Server) move(exec kizcool.ExecutionEvent, urls []kizcool.DeviceURL) {
+	s.mux.Lock()
+	step, travel := s.step, s.travel
+	s.mux.Unlock()
+	if step <= 0 {
+		step = travel / 10
+	}
+	delta := 100 * float64(step) / float64(travel)
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mux.Lock()
+		active := false
+		for _, url := range urls {
+			m, ok := s.moving[url]
+			if !ok || m.execID != exec.ExecID {
+				continue
+			}
+			d := s.device(url)
+			current, _ := closure(*d)
+			next := float64(m.target)
+			if current < next-delta {
+				next = current + delta
+			} else if current > next+delta {
+				next = current - delta
+			}
+			position := int(next + 0.5)
+			if position == m.target {
+				delete(s.moving, url)
+			} else {
+				active = true
+			}
+			changed := SetStates(d, CommandStates(*d, kizcool.Command{Name: kizcool.CmdSetClosure, Parameters: []int{position}}))
+			if len(changed) > 0 {
+				s.emit(StateEvent(s.setup.OID, url, changed))
+			}
+		}
+		if !active {
+			s.completed(exec)
+			s.mux.Unlock()
+			return
+		}
+		s.mux.Unlock()
+	}
+}
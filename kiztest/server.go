@@ -0,0 +1,549 @@
+// Package kiztest provides a stateful fake Overkiz server to test code using the kizcool and api packages.
+//
+// The server handles logins with session cookies, serves a setup loaded from fixtures, applies
+// executed commands to the device states and emits the resulting events to the registered
+// listeners. Error responses can be injected to test error handling. Executor records
+// executions without a server, e.g. for the rules engine and the scheduler.
+//
+//	s := kiztest.NewServer()
+//	defer s.Close()
+//	if err := s.LoadDevicesFile("testdata/getDevices.json"); err != nil { ... }
+//	kiz, err := s.NewKiz()
+package kiztest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Credentials accepted by a new server
+const (
+	Username = "user@example.com"
+	Password = "password"
+)
+
+// SetupOID is the OID of the setup served when the fixture has none
+const SetupOID = "77777777-5555-4444-8888-bbbbbbbbbbbb"
+
+// Fault is an error response of the server
+type Fault struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Faults returned by the Overkiz servers, to inject with Server.Inject
+var (
+	FaultNotAuthenticated = Fault{http.StatusUnauthorized, "RESOURCE_ACCESS_DENIED", "Not authenticated"}
+	FaultBadCredentials   = Fault{http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Bad credentials"}
+	FaultTooManyRequests  = Fault{http.StatusUnauthorized, "AUTHENTICATION_ERROR", "Too many requests, try again later : login with " + Username}
+	FaultNoListener       = Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", "No registered event listener"}
+)
+
+type injectedFault struct {
+	path string
+	Fault
+}
+
+// Server is a fake Overkiz server. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mux          sync.Mutex
+	username     string
+	password     string
+	sessionTTL   time.Duration
+	setup        kizcool.Setup
+	actionGroups []kizcool.ActionGroup
+	sessions     map[string]time.Time // creation time by session id
+	listeners    map[string][]kizcool.Event
+	faults       []injectedFault
+	requests     []string
+	travel       time.Duration
+	step         time.Duration
+	moving       map[kizcool.DeviceURL]movement
+	running      map[kizcool.ExecID]kizcool.Execution
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// NewServer starts a server with an empty setup, accepting Username and Password. Close it when done.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a server that is not started, e.g. to listen on a given address.
+// Call Start when done configuring it, and Close when done.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		username:  Username,
+		password:  Password,
+		setup:     kizcool.Setup{OID: SetupOID},
+		sessions:  make(map[string]time.Time),
+		listeners: make(map[string][]kizcool.Event),
+		moving:    make(map[kizcool.DeviceURL]movement),
+		running:   make(map[kizcool.ExecID]kizcool.Execution),
+		done:      make(chan struct{}),
+	}
+	s.Server = httptest.NewUnstartedServer(s)
+	return s
+}
+
+// Close stops the movements in progress and shuts down the server
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.Server.Close()
+}
+
+// NewKiz returns a client of the server, with its own session
+func (s *Server) NewKiz(opts ...api.Option) (*kizcool.Kiz, error) {
+	s.mux.Lock()
+	username, password := s.username, s.password
+	s.mux.Unlock()
+	clt, err := api.NewWithHTTPClient(username, password, s.URL, "", &http.Client{Transport: s.Client().Transport}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return kizcool.NewWithAPIClient(clt)
+}
+
+// SetCredentials sets the accepted username and password
+func (s *Server) SetCredentials(username, password string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.username, s.password = username, password
+}
+
+// SetSessionTTL sets how long sessions remain valid, forever if 0
+func (s *Server) SetSessionTTL(ttl time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.sessionTTL = ttl
+}
+
+// ExpireSessions invalidates all the sessions, so that clients must login again
+func (s *Server) ExpireSessions() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.sessions = make(map[string]time.Time)
+}
+
+// SetSetup replaces the setup served, including its devices
+func (s *Server) SetSetup(setup kizcool.Setup) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if setup.OID == "" {
+		setup.OID = SetupOID
+	}
+	s.setup = setup
+}
+
+// SetDevices replaces the devices of the setup
+func (s *Server) SetDevices(devices []kizcool.Device) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.setup.Devices = devices
+}
+
+// SetActionGroups replaces the action groups (scenarios) stored on the server
+func (s *Server) SetActionGroups(ags []kizcool.ActionGroup) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.actionGroups = ags
+}
+
+// LoadSetupFile loads the setup from a json file, either the response to /setup or
+// an object with the setup under the "setup" key, as in testdata/getSetup.json
+func (s *Server) LoadSetupFile(path string) error {
+	setup, err := ReadSetupFile(path)
+	if err != nil {
+		return err
+	}
+	s.SetSetup(setup)
+	return nil
+}
+
+// ReadSetupFile reads a setup from a json file in the formats accepted by LoadSetupFile
+func ReadSetupFile(path string) (kizcool.Setup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return kizcool.Setup{}, err
+	}
+	var wrapped struct {
+		Setup *kizcool.Setup
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return kizcool.Setup{}, fmt.Errorf("Error parsing setup %s: %w", path, err)
+	}
+	if wrapped.Setup != nil {
+		return *wrapped.Setup, nil
+	}
+	var setup kizcool.Setup
+	if err := json.Unmarshal(data, &setup); err != nil {
+		return setup, fmt.Errorf("Error parsing setup %s: %w", path, err)
+	}
+	return setup, nil
+}
+
+// LoadFile loads the devices from a json file holding either a list of devices or a setup,
+// see LoadDevicesFile and LoadSetupFile
+func (s *Server) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		return s.LoadDevicesFile(path)
+	}
+	return s.LoadSetupFile(path)
+}
+
+// LoadDevicesFile loads the devices from a json file, as in testdata/getDevices.json
+func (s *Server) LoadDevicesFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var devices []kizcool.Device
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return fmt.Errorf("Error parsing devices %s: %w", path, err)
+	}
+	s.SetDevices(devices)
+	return nil
+}
+
+// LoadActionGroupsFile loads the action groups from a json file, as in testdata/getActionGroups.json
+func (s *Server) LoadActionGroupsFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var ags []kizcool.ActionGroup
+	if err := json.Unmarshal(data, &ags); err != nil {
+		return fmt.Errorf("Error parsing action groups %s: %w", path, err)
+	}
+	s.SetActionGroups(ags)
+	return nil
+}
+
+// Devices returns a copy of the current devices
+func (s *Server) Devices() []kizcool.Device {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	devices := make([]kizcool.Device, len(s.setup.Devices))
+	for i, d := range s.setup.Devices {
+		devices[i] = d
+		devices[i].States = append([]kizcool.DeviceState(nil), d.States...)
+	}
+	return devices
+}
+
+// State returns the current state of a device
+func (s *Server) State(url kizcool.DeviceURL, name kizcool.StateName) (kizcool.DeviceState, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	d := s.device(url)
+	if d == nil {
+		return kizcool.DeviceState{}, false
+	}
+	for _, st := range d.States {
+		if st.Name == name {
+			return st, true
+		}
+	}
+	return kizcool.DeviceState{}, false
+}
+
+// SetState changes the state of a device, as if it changed physically, and emits a DeviceStateChangedEvent
+func (s *Server) SetState(url kizcool.DeviceURL, name kizcool.StateName, value interface{}) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	d := s.device(url)
+	if d == nil {
+		return fmt.Errorf("No device with URL %s", url)
+	}
+	changed := AddStates(d, []kizcool.DeviceState{{Name: name, Type: StateType(value), Value: value}})
+	if len(changed) > 0 {
+		s.emit(StateEvent(s.setup.OID, url, changed))
+	}
+	return nil
+}
+
+// Emit sends the events to all the registered listeners
+func (s *Server) Emit(events ...kizcool.Event) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.emit(events...)
+}
+
+// Inject makes the next request whose path starts with path fail with the fault.
+// Paths are relative to /enduserAPI, e.g. /exec/apply or /events. Faults are used once, in order.
+func (s *Server) Inject(path string, f Fault) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.faults = append(s.faults, injectedFault{path: path, Fault: f})
+}
+
+// Requests returns the requests received so far, as "METHOD path"
+func (s *Server) Requests() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// ServeHTTP implements the Overkiz enduser api
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	path := strings.TrimPrefix(req.URL.EscapedPath(), "/enduserAPI")
+	s.requests = append(s.requests, req.Method+" "+path)
+	for i, f := range s.faults {
+		if strings.HasPrefix(path, f.path) {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			writeFault(rw, f.Fault)
+			return
+		}
+	}
+	if path == "/login" && req.Method == http.MethodPost {
+		s.login(rw, req)
+		return
+	}
+	if !s.authenticated(req) {
+		writeFault(rw, FaultNotAuthenticated)
+		return
+	}
+	var segments []string
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		unescaped, err := url.QueryUnescape(seg)
+		if err != nil {
+			writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", err.Error()})
+			return
+		}
+		segments = append(segments, unescaped)
+	}
+	s.route(rw, req, segments)
+}
+
+// route dispatches an authenticated request
+func (s *Server) route(rw http.ResponseWriter, req *http.Request, seg []string) {
+	get, post := req.Method == http.MethodGet, req.Method == http.MethodPost
+	switch {
+	case get && match(seg, "setup"):
+		writeJSON(rw, s.setup)
+	case get && match(seg, "setup", "devices"):
+		writeJSON(rw, s.setup.Devices)
+	case req.Method == http.MethodPut && match(seg, "setup", "devices", "states", "refresh"):
+		for _, d := range s.setup.Devices {
+			if len(d.States) > 0 {
+				s.emit(StateEvent(s.setup.OID, d.DeviceURL, d.States))
+			}
+		}
+		s.emit(&kizcool.RefreshAllDevicesStatesCompletedEvent{GenericEvent: NewGenericEvent("RefreshAllDevicesStatesCompletedEvent")})
+		writeJSON(rw, struct{}{})
+	case get && match(seg, "setup", "devices", "*"):
+		if d := s.device(kizcool.DeviceURL(seg[2])); d != nil {
+			writeJSON(rw, d)
+			return
+		}
+		writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", "Invalid device URL"})
+	case get && match(seg, "setup", "devices", "*", "states", "*"):
+		if d := s.device(kizcool.DeviceURL(seg[2])); d != nil {
+			for _, st := range d.States {
+				if string(st.Name) == seg[4] {
+					writeJSON(rw, st)
+					return
+				}
+			}
+		}
+		writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", "Invalid device URL or state name"})
+	case get && match(seg, "actionGroups"):
+		writeJSON(rw, s.actionGroups)
+	case get && match(seg, "exec", "current"):
+		executions := []kizcool.Execution{}
+		for _, e := range s.running {
+			executions = append(executions, e)
+		}
+		writeJSON(rw, executions)
+	case post && match(seg, "exec", "apply"):
+		var ag kizcool.ActionGroup
+		if err := json.NewDecoder(req.Body).Decode(&ag); err != nil {
+			writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", "Invalid action group: " + err.Error()})
+			return
+		}
+		s.execute(rw, ag)
+	case post && match(seg, "exec", "*"):
+		for _, ag := range s.actionGroups {
+			if ag.OID == seg[1] {
+				s.execute(rw, ag)
+				return
+			}
+		}
+		writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", "Unknown action group " + seg[1]})
+	case post && match(seg, "events", "register"):
+		id := newID()
+		s.listeners[id] = []kizcool.Event{}
+		writeJSON(rw, map[string]string{"id": id})
+	case post && match(seg, "events", "*", "fetch"):
+		events, ok := s.listeners[seg[1]]
+		if !ok {
+			writeFault(rw, FaultNoListener)
+			return
+		}
+		s.listeners[seg[1]] = []kizcool.Event{}
+		writeJSON(rw, events)
+	case post && match(seg, "events", "*", "unregister"):
+		delete(s.listeners, seg[1])
+		writeJSON(rw, struct{}{})
+	default:
+		writeFault(rw, Fault{http.StatusNotFound, "RESOURCE_NOT_FOUND", "Unknown resource " + req.Method + " " + req.URL.Path})
+	}
+}
+
+// match tells if the path segments match the pattern, where * matches any segment
+func match(segments []string, pattern ...string) bool {
+	if len(segments) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// login checks the credentials and opens a session
+func (s *Server) login(rw http.ResponseWriter, req *http.Request) {
+	if req.FormValue("userId") != s.username || req.FormValue("userPassword") != s.password {
+		writeFault(rw, FaultBadCredentials)
+		return
+	}
+	id := newID()
+	s.sessions[id] = time.Now()
+	http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: id, Path: "/"})
+	s.emit(&kizcool.EndUserLoginEvent{GenericEvent: NewGenericEvent("EndUserLoginEvent"), SetupOID: s.setup.OID, UserID: s.username})
+	writeJSON(rw, map[string]interface{}{"success": true, "roles": []map[string]string{{"name": "ENDUSER"}}})
+}
+
+// authenticated tells if the request has a valid session, and forgets expired sessions
+func (s *Server) authenticated(req *http.Request) bool {
+	cookie, err := req.Cookie("JSESSIONID")
+	if err != nil {
+		return false
+	}
+	created, ok := s.sessions[cookie.Value]
+	if ok && s.sessionTTL > 0 && time.Since(created) > s.sessionTTL {
+		delete(s.sessions, cookie.Value)
+		return false
+	}
+	return ok
+}
+
+// execute checks the action group, applies its commands and emits the events of the execution
+func (s *Server) execute(rw http.ResponseWriter, ag kizcool.ActionGroup) {
+	for _, a := range ag.Actions {
+		d := s.device(a.DeviceURL)
+		if d == nil {
+			writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", "Invalid device URL " + string(a.DeviceURL)})
+			return
+		}
+		for _, c := range a.Commands {
+			if !kizcool.SupportsCommand(*d, c) {
+				writeFault(rw, Fault{http.StatusBadRequest, "UNSPECIFIED_ERROR", fmt.Sprintf("Command %s not supported by %s", c.Name, a.DeviceURL)})
+				return
+			}
+		}
+	}
+	id := kizcool.ExecID(newID())
+	exec := kizcool.ExecutionEvent{ExecID: id, SetupOID: s.setup.OID, Type: 1, SubType: 1}
+	s.emit(
+		&kizcool.ExecutionRegisteredEvent{GenericEvent: NewGenericEvent("ExecutionRegisteredEvent"), ExecutionEvent: exec,
+			Label: ag.Label, Actions: ag.Actions},
+		&kizcool.ExecutionStateChangedEvent{GenericEvent: NewGenericEvent("ExecutionStateChangedEvent"), ExecutionEvent: exec,
+			OldState: "INITIALIZED", NewState: "IN_PROGRESS"},
+	)
+	var moving []kizcool.DeviceURL
+	for _, a := range ag.Actions {
+		d := s.device(a.DeviceURL)
+		for _, c := range a.Commands {
+			if c.Name == kizcool.CmdStop {
+				delete(s.moving, d.DeviceURL)
+				continue
+			}
+			states := CommandStates(*d, c)
+			if s.travel > 0 {
+				if target, ok := closureTarget(*d, states); ok {
+					s.moving[d.DeviceURL] = movement{target: target, execID: id}
+					moving = append(moving, d.DeviceURL)
+					continue
+				}
+			}
+			if changed := SetStates(d, states); len(changed) > 0 {
+				s.emit(StateEvent(s.setup.OID, d.DeviceURL, changed))
+			}
+		}
+	}
+	if len(moving) > 0 {
+		s.running[id] = kizcool.Execution{ID: id, State: "IN_PROGRESS", ActionGroup: ag,
+			StartTime: int(time.Now().UnixNano() / int64(time.Millisecond)), Owner: s.username}
+		go s.move(exec, moving)
+	} else {
+		s.completed(exec)
+	}
+	writeJSON(rw, map[string]kizcool.ExecID{"execId": id})
+}
+
+// completed emits the end of the execution
+func (s *Server) completed(exec kizcool.ExecutionEvent) {
+	delete(s.running, exec.ExecID)
+	s.emit(&kizcool.ExecutionStateChangedEvent{GenericEvent: NewGenericEvent("ExecutionStateChangedEvent"), ExecutionEvent: exec,
+		OldState: "IN_PROGRESS", NewState: "COMPLETED"})
+}
+
+// device returns the device with the url, nil if there is none
+func (s *Server) device(url kizcool.DeviceURL) *kizcool.Device {
+	for i := range s.setup.Devices {
+		if s.setup.Devices[i].DeviceURL == url {
+			return &s.setup.Devices[i]
+		}
+	}
+	return nil
+}
+
+// emit queues the events for all the listeners
+func (s *Server) emit(events ...kizcool.Event) {
+	for id := range s.listeners {
+		s.listeners[id] = append(s.listeners[id], events...)
+	}
+}
+
+// newID returns a random id formatted like an uuid
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeFault(rw http.ResponseWriter, f Fault) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(f.Status)
+	json.NewEncoder(rw).Encode(map[string]string{"errorCode": f.Code, "error": f.Message})
+}
@@ -0,0 +1,205 @@
+package kiztest
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/stretchr/testify/assert"
+)
+
+const volet1 = kizcool.DeviceURL("io://1111-0000-4444/22222222")
+
+func helperServer(t *testing.T) (*Server, *kizcool.Kiz) {
+	s := NewServer()
+	assert.NoError(t, s.LoadSetupFile(filepath.Join("..", "testdata", "getSetup.json")))
+	assert.NoError(t, s.LoadActionGroupsFile(filepath.Join("..", "testdata", "getActionGroups.json")))
+	kiz, err := s.NewKiz()
+	assert.NoError(t, err)
+	return s, kiz
+}
+
+func TestLogin(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+	assert.NoError(t, kiz.Login())
+	assert.NotEmpty(t, kiz.SessionID())
+
+	s.SetCredentials(Username, "other")
+	s.ExpireSessions()
+	_, err := kiz.GetDevices()
+	assert.Error(t, err)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+	s.SetSessionTTL(time.Millisecond)
+	_, err := kiz.GetDevices()
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	devices, err := kiz.GetDevices()
+	assert.NoError(t, err)
+	assert.Len(t, devices, 19)
+	assert.Equal(t, 2, kiz.Stats().Logins)
+}
+
+func TestGetSetupAndDevices(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+	setup, err := kiz.GetSetup()
+	assert.NoError(t, err)
+	assert.Equal(t, "Europe/London", setup.Location.Timezone)
+	device, err := kiz.GetDevice(volet1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Volet1", device.Label)
+	state, err := kiz.GetDeviceState(volet1, kizcool.StateClosure)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), state.Value)
+	devices, err := kiz.GetDevicesByText("class=RollerShutter")
+	assert.NoError(t, err)
+	assert.Len(t, devices, 5)
+	ags, err := kiz.GetActionGroups()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ags)
+}
+
+func TestExecuteEmitsEvents(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+	events, err := kiz.PollEvents()
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	device, err := kiz.GetDevice(volet1)
+	assert.NoError(t, err)
+	id, err := kiz.SetClosure(device, 30)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id)
+	state, _ := s.State(volet1, kizcool.StateClosure)
+	assert.Equal(t, 30, state.Value)
+
+	events, err = kiz.PollEvents()
+	assert.NoError(t, err)
+	var names []string
+	for _, e := range events {
+		names = append(names, kizcool.EventName(e))
+	}
+	assert.Equal(t, []string{"ExecutionRegisteredEvent", "ExecutionStateChangedEvent",
+		"DeviceStateChangedEvent", "ExecutionStateChangedEvent"}, names)
+	dsce := events[2].(*kizcool.DeviceStateChangedEvent)
+	assert.Equal(t, volet1, dsce.DeviceURL)
+	assert.Equal(t, float64(30), dsce.DeviceStates[0].Value)
+	assert.Equal(t, "COMPLETED", events[3].(*kizcool.ExecutionStateChangedEvent).NewState)
+	assert.Equal(t, id, events[3].(*kizcool.ExecutionStateChangedEvent).ExecID)
+
+	_, err = kiz.SetIntensity(device, 30)
+	assert.Error(t, err)
+}
+
+func TestSetStateAndRefresh(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+	_, err := kiz.PollEvents()
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetState(volet1, "core:RainState", "detected"))
+	assert.Error(t, s.SetState("io://0000-0000-0000/0", "core:RainState", "detected"))
+	events, err := kiz.PollEvents()
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "detected", events[0].(*kizcool.DeviceStateChangedEvent).DeviceStates[0].Value)
+	}
+	assert.NoError(t, kiz.RefreshStates())
+	events, err = kiz.PollEvents()
+	assert.NoError(t, err)
+	assert.Equal(t, "RefreshAllDevicesStatesCompletedEvent", kizcool.EventName(events[len(events)-1]))
+}
+
+func TestInjectedFaults(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+
+	// an authentication error triggers a new login and the request is sent again, with its body
+	device, err := kiz.GetDevice(volet1)
+	assert.NoError(t, err)
+	s.Inject("/exec/apply", FaultNotAuthenticated)
+	_, err = kiz.Close(device)
+	assert.NoError(t, err)
+	state, _ := s.State(volet1, kizcool.StateClosure)
+	assert.Equal(t, 100, state.Value)
+
+	s.Inject("/login", FaultTooManyRequests)
+	s.Inject("/setup", FaultNotAuthenticated)
+	_, err = kiz.GetSetup()
+	assert.Error(t, err)
+	assert.Equal(t, 1, kiz.Stats().Errors["too_many_requests"])
+
+	// a lost listener is registered again
+	_, err = kiz.PollEvents()
+	assert.NoError(t, err)
+	s.Inject("/events/", FaultNoListener)
+	_, err = kiz.PollEvents()
+	assert.NoError(t, err)
+
+	var registrations int
+	for _, r := range s.Requests() {
+		if r == http.MethodPost+" /events/register" {
+			registrations++
+		}
+	}
+	assert.Equal(t, 2, registrations)
+}
+
+func TestMotion(t *testing.T) {
+	s, kiz := helperServer(t)
+	defer s.Close()
+	s.SetMotion(100*time.Millisecond, 10*time.Millisecond)
+	_, err := kiz.PollEvents()
+	assert.NoError(t, err)
+	device, err := kiz.GetDevice(volet1)
+	assert.NoError(t, err)
+
+	_, err = kiz.SetClosure(device, 50)
+	assert.NoError(t, err)
+	executions, err := kiz.GetCurrentExecutions()
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var closures []interface{}
+	var completed bool
+	for !completed && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+		events, err := kiz.PollEvents()
+		assert.NoError(t, err)
+		for _, e := range events {
+			switch ev := e.(type) {
+			case *kizcool.DeviceStateChangedEvent:
+				closures = append(closures, ev.DeviceStates[0].Value)
+			case *kizcool.ExecutionStateChangedEvent:
+				completed = ev.NewState == "COMPLETED"
+			}
+		}
+	}
+	assert.True(t, completed)
+	assert.Equal(t, []interface{}{float64(10), float64(20), float64(30), float64(40), float64(50)}, closures)
+	executions, err = kiz.GetCurrentExecutions()
+	assert.NoError(t, err)
+	assert.Empty(t, executions)
+
+	// stop interrupts the movement
+	_, err = kiz.Close(device)
+	assert.NoError(t, err)
+	time.Sleep(25 * time.Millisecond)
+	_, err = kiz.Stop(device)
+	assert.NoError(t, err)
+	stopped, _ := s.State(volet1, kizcool.StateClosure)
+	time.Sleep(30 * time.Millisecond)
+	state, _ := s.State(volet1, kizcool.StateClosure)
+	assert.Equal(t, stopped.Value, state.Value)
+	assert.True(t, state.Value.(int) > 50 && state.Value.(int) < 100)
+}
@@ -0,0 +1,132 @@
+package kiztest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// CommandStates returns the states of the device once the command is done.
+// Closures follow the Overkiz convention: 0 is open and 100 closed. Unknown commands change nothing.
+func CommandStates(device kizcool.Device, c kizcool.Command) []kizcool.DeviceState {
+	var states []kizcool.DeviceState
+	closure := func(v int) {
+		states = append(states, kizcool.DeviceState{Name: kizcool.StateClosure, Type: kizcool.StateInt, Value: v})
+		openClosed := "open"
+		if v == 100 {
+			openClosed = "closed"
+		}
+		states = append(states, kizcool.DeviceState{Name: "core:OpenClosedState", Type: kizcool.StateString, Value: openClosed})
+	}
+	onOff := func(on bool) {
+		value := "off"
+		if on {
+			value = "on"
+		}
+		states = append(states, kizcool.DeviceState{Name: kizcool.StateOnOff, Type: kizcool.StateString, Value: value})
+	}
+	switch c.Name {
+	case kizcool.CmdOpen, kizcool.CmdUp:
+		closure(0)
+	case kizcool.CmdClose, kizcool.CmdDown:
+		closure(100)
+	case kizcool.CmdSetClosure:
+		if v, ok := intParameter(c); ok {
+			closure(v)
+		}
+	case kizcool.CmdOn:
+		onOff(true)
+	case kizcool.CmdOff:
+		onOff(false)
+	case kizcool.CmdSetIntensity:
+		if v, ok := intParameter(c); ok {
+			states = append(states, kizcool.DeviceState{Name: kizcool.StateLightIntensity, Type: kizcool.StateInt, Value: v})
+			onOff(v > 0)
+		}
+	}
+	return states
+}
+
+// SetStates updates the states the device has and returns those that changed
+func SetStates(d *kizcool.Device, states []kizcool.DeviceState) []kizcool.DeviceState {
+	var changed []kizcool.DeviceState
+	for _, st := range states {
+		for i, current := range d.States {
+			if current.Name != st.Name {
+				continue
+			}
+			if fmt.Sprint(current.Value) != fmt.Sprint(st.Value) {
+				d.States[i].Value = st.Value
+				changed = append(changed, d.States[i])
+			}
+			break
+		}
+	}
+	return changed
+}
+
+// AddStates updates the states of the device, adding those it does not have, and returns those that changed
+func AddStates(d *kizcool.Device, states []kizcool.DeviceState) []kizcool.DeviceState {
+	changed := SetStates(d, states)
+	for _, st := range states {
+		if !hasState(*d, st.Name) {
+			d.States = append(d.States, st)
+			changed = append(changed, st)
+		}
+	}
+	return changed
+}
+
+// hasState tells if the device has the named state
+func hasState(d kizcool.Device, name kizcool.StateName) bool {
+	for _, st := range d.States {
+		if st.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// StateEvent returns the event of the states of a device changing
+func StateEvent(setupOID string, url kizcool.DeviceURL, states []kizcool.DeviceState) kizcool.Event {
+	return &kizcool.DeviceStateChangedEvent{
+		GenericEvent: NewGenericEvent("DeviceStateChangedEvent"),
+		SetupOID:     setupOID,
+		DeviceURL:    url,
+		DeviceStates: append([]kizcool.DeviceState(nil), states...),
+	}
+}
+
+// NewGenericEvent returns the common part of an event with the name, timestamped now
+func NewGenericEvent(name string) kizcool.GenericEvent {
+	return kizcool.GenericEvent{Name: name, Timestamp: int(time.Now().UnixNano() / int64(time.Millisecond))}
+}
+
+// intParameter returns the first parameter of the command as an int
+func intParameter(c kizcool.Command) (int, bool) {
+	switch params := c.Parameters.(type) {
+	case []int:
+		if len(params) > 0 {
+			return params[0], true
+		}
+	case []interface{}:
+		if len(params) > 0 {
+			if f, ok := (kizcool.DeviceState{Value: params[0]}).Float(); ok {
+				return int(f), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// StateType returns the type of a state with the value
+func StateType(value interface{}) kizcool.StateType {
+	switch value.(type) {
+	case int:
+		return kizcool.StateInt
+	case float64:
+		return kizcool.StateFloat
+	}
+	return kizcool.StateString
+}
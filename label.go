@@ -0,0 +1,118 @@
+package kizcool
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// LabelError is returned when a label does not designate exactly one device.
+// If several devices have the label, Ambiguous is true and Candidates are those devices.
+// If no device has it, Candidates are the closest labels, best first, and may be empty.
+type LabelError struct {
+	Label      string
+	Ambiguous  bool
+	Candidates []Device
+}
+
+func (e *LabelError) Error() string {
+	if e.Ambiguous {
+		return "More than one device with that label"
+	}
+	return "No device with that label"
+}
+
+// maxSuggestions is the number of near-matches returned in a LabelError
+const maxSuggestions = 3
+
+// SuggestDevices returns up to max devices with a label close to the given one, best first.
+// Labels are compared ignoring case and accents. A label starting with the given text ranks
+// before labels within a small edit distance.
+func SuggestDevices(label string, devices []Device, max int) []Device {
+	type candidate struct {
+		device Device
+		score  int
+	}
+	query := foldLabel(label)
+	var candidates []candidate
+	for _, d := range devices {
+		if score, ok := labelScore(query, foldLabel(d.Label)); ok {
+			candidates = append(candidates, candidate{d, score})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].device.Label < candidates[j].device.Label
+	})
+	var result []Device
+	for i := 0; i < len(candidates) && i < max; i++ {
+		result = append(result, candidates[i].device)
+	}
+	return result
+}
+
+// labelScore tells how close two folded labels are, lower is better
+func labelScore(query, label string) (int, bool) {
+	if query == "" || label == "" {
+		return 0, false
+	}
+	if query == label {
+		return 0, true
+	}
+	if strings.HasPrefix(label, query) {
+		return 1, true
+	}
+	distance := levenshtein(query, label)
+	threshold := len([]rune(query)) / 3
+	if threshold < 2 {
+		threshold = 2
+	}
+	if distance > threshold {
+		return 0, false
+	}
+	return 1 + distance, true
+}
+
+// foldLabel lowercases the label, removes accents and extra spaces
+func foldLabel(label string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, label)
+	if err != nil {
+		folded = label
+	}
+	return strings.Join(strings.Fields(strings.ToLower(folded)), " ")
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,235 @@
+// Package mqttbridge publishes device states to mqtt and runs commands received from mqtt.
+//
+// Topics, with the default prefix:
+//
+//	kizcool/status                       online or offline, for the bridge itself
+//	kizcool/<device>/<state>             last value of each device state, retained
+//	kizcool/<device>/availability        online or offline
+//	kizcool/<device>/set                 commands to the device, see ParseCommand
+//
+// <device> is the device URL made safe for topics, see DeviceID. Home Assistant discovery
+// payloads are published under the discovery prefix for covers, lights and alarms.
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Defaults for the topic prefixes
+const (
+	DefaultPrefix          = "kizcool"
+	DefaultDiscoveryPrefix = "homeassistant"
+)
+
+// Payloads of availability topics
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Bridge links the devices of a Kiz to an mqtt broker
+type Bridge struct {
+	// Prefix of all device topics
+	Prefix string
+	// DiscoveryPrefix is the Home Assistant discovery prefix, discovery is disabled if empty
+	DiscoveryPrefix string
+
+	kiz    kizcool.Client
+	broker Broker
+	logger api.Logger
+
+	mux     sync.Mutex
+	devices map[string]kizcool.Device
+}
+
+// Option configures a Bridge, see New
+type Option func(*Bridge)
+
+// WithLogger makes the bridge log polling, publishing and command errors to l.
+func WithLogger(l api.Logger) Option {
+	return func(b *Bridge) {
+		if l == nil {
+			l = api.NopLogger{}
+		}
+		b.logger = l
+	}
+}
+
+// New returns a bridge with the default prefixes
+func New(kiz kizcool.Client, broker Broker, opts ...Option) *Bridge {
+	b := &Bridge{
+		Prefix:          DefaultPrefix,
+		DiscoveryPrefix: DefaultDiscoveryPrefix,
+		kiz:             kiz,
+		broker:          broker,
+		logger:          api.NopLogger{},
+		devices:         make(map[string]kizcool.Device),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// DeviceID returns an identifier of the device usable in topics,
+// e.g. io_1111-0000-4444_11784413 for io://1111-0000-4444/11784413
+func DeviceID(url kizcool.DeviceURL) string {
+	return strings.NewReplacer("://", "_", "/", "_", "+", "_", "#", "_").Replace(string(url))
+}
+
+// StatusTopic is the topic of the availability of the bridge. It can be used as the mqtt will.
+func (b *Bridge) StatusTopic() string {
+	return b.Prefix + "/status"
+}
+
+func (b *Bridge) deviceTopic(d kizcool.Device, suffix string) string {
+	return b.Prefix + "/" + DeviceID(d.DeviceURL) + "/" + suffix
+}
+
+// Start publishes discovery payloads and the current state of all devices, then subscribes to commands
+func (b *Bridge) Start() error {
+	devices, err := b.kiz.GetDevices()
+	if err != nil {
+		return err
+	}
+	b.mux.Lock()
+	for _, d := range devices {
+		b.devices[DeviceID(d.DeviceURL)] = d
+	}
+	b.mux.Unlock()
+	for _, d := range devices {
+		if b.DiscoveryPrefix != "" {
+			if err := b.publishDiscovery(d); err != nil {
+				return err
+			}
+		}
+		if err := b.publishAvailability(d, d.Available); err != nil {
+			return err
+		}
+		if err := b.publishStates(d, d.States); err != nil {
+			return err
+		}
+	}
+	if err := b.broker.Subscribe(b.Prefix+"/+/set", b.handleSet); err != nil {
+		return err
+	}
+	return b.broker.Publish(b.StatusTopic(), []byte(Online), true)
+}
+
+// Run starts the bridge then publishes state changes received as events until the context is done.
+// Polling errors are logged and polling resumes after a pause.
+func (b *Bridge) Run(ctx context.Context) error {
+	if err := b.Start(); err != nil {
+		return err
+	}
+	for event := range kizcool.ListenEvents(ctx, b.kiz, b.logger) {
+		if err := b.Handle(event); err != nil {
+			b.logger.Error("Error publishing event", "err", err)
+		}
+	}
+	return b.broker.Publish(b.StatusTopic(), []byte(Offline), true)
+}
+
+// Handle publishes the states and availability changes carried by the event
+func (b *Bridge) Handle(e kizcool.Event) error {
+	switch t := e.(type) {
+	case *kizcool.DeviceStateChangedEvent:
+		return b.publishStates(kizcool.Device{DeviceURL: t.DeviceURL}, t.DeviceStates)
+	case *kizcool.DeviceAvailableEvent:
+		return b.publishAvailability(kizcool.Device{DeviceURL: t.DeviceURL}, true)
+	case *kizcool.DeviceUnavailableEvent:
+		return b.publishAvailability(kizcool.Device{DeviceURL: t.DeviceURL}, false)
+	}
+	return nil
+}
+
+func (b *Bridge) publishStates(d kizcool.Device, states []kizcool.DeviceState) error {
+	for _, s := range states {
+		if err := b.broker.Publish(b.deviceTopic(d, string(s.Name)), []byte(fmt.Sprint(s.Value)), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) publishAvailability(d kizcool.Device, available bool) error {
+	payload := Offline
+	if available {
+		payload = Online
+	}
+	return b.broker.Publish(b.deviceTopic(d, "availability"), []byte(payload), true)
+}
+
+// handleSet runs the command received on a set topic. Retained commands are ignored, so that
+// they are not run again each time the bridge starts.
+func (b *Bridge) handleSet(topic string, payload []byte, retained bool) {
+	if retained {
+		b.logger.Warn("Ignoring retained command", "topic", topic, "payload", string(payload))
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(topic, b.Prefix+"/"), "/set")
+	b.mux.Lock()
+	device, ok := b.devices[id]
+	b.mux.Unlock()
+	if !ok {
+		b.logger.Error("Command for unknown device", "topic", topic, "payload", string(payload))
+		return
+	}
+	command, err := ParseCommand(payload)
+	if err != nil {
+		b.logger.Error("Invalid command", "topic", topic, "payload", string(payload), "err", err)
+		return
+	}
+	ag, err := kizcool.ActionGroupWithOneCommand(device, command)
+	if err != nil {
+		b.logger.Error("Unsupported command", "topic", topic, "payload", string(payload), "err", err)
+		return
+	}
+	if _, err := b.kiz.Execute(ag); err != nil {
+		b.logger.Error("Error executing command", "topic", topic, "payload", string(payload), "err", err)
+	}
+}
+
+// ParseCommand parses the payload of a set topic. It is either a command name followed by
+// its parameters separated by spaces, e.g. "setClosure 50", or a json command,
+// e.g. {"name": "setClosure", "parameters": [50]}. Numeric parameters are sent as numbers.
+func ParseCommand(payload []byte) (kizcool.Command, error) {
+	text := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(text, "{") {
+		var command kizcool.Command
+		if err := json.Unmarshal([]byte(text), &command); err != nil {
+			return kizcool.Command{}, fmt.Errorf("Invalid json command: %w", err)
+		}
+		if command.Name == "" {
+			return kizcool.Command{}, fmt.Errorf("Missing command name in %s", text)
+		}
+		return command, nil
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return kizcool.Command{}, fmt.Errorf("Empty command")
+	}
+	command := kizcool.Command{Name: fields[0]}
+	if len(fields) > 1 {
+		var params []interface{}
+		for _, f := range fields[1:] {
+			if n, err := strconv.Atoi(f); err == nil {
+				params = append(params, n)
+			} else if x, err := strconv.ParseFloat(f, 64); err == nil {
+				params = append(params, x)
+			} else {
+				params = append(params, f)
+			}
+		}
+		command.Parameters = params
+	}
+	return command, nil
+}
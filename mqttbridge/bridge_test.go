@@ -0,0 +1,158 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestBridge returns a started bridge whose server serves the test devices and records executions
+func getTestBridge(t *testing.T) (*Bridge, *MemoryBroker, *[]kizcool.ActionGroup, *httptest.Server) {
+	bridge, broker, executed, server := getTestBridgeNotStarted(t)
+	assert.NoError(t, bridge.Start())
+	return bridge, broker, executed, server
+}
+
+// getTestBridgeNotStarted returns the bridge of getTestBridge before it is started
+func getTestBridgeNotStarted(t *testing.T) (*Bridge, *MemoryBroker, *[]kizcool.ActionGroup, *httptest.Server) {
+	devices, err := ioutil.ReadFile(filepath.Join("..", "testdata", "getDevices.json"))
+	assert.NoError(t, err)
+	var executed []kizcool.ActionGroup
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.String() {
+		case "/enduserAPI/setup/devices":
+			rw.Write(devices)
+		case "/enduserAPI/exec/apply":
+			var ag kizcool.ActionGroup
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&ag))
+			executed = append(executed, ag)
+			rw.Write([]byte(`{"execId": "abc"}`))
+		default:
+			t.Errorf("Unexpected request %s", req.URL)
+		}
+	}))
+	ac, err := api.NewWithHTTPClient("", "", server.URL, "", server.Client())
+	assert.NoError(t, err)
+	kiz, _ := kizcool.NewWithAPIClient(ac)
+	broker := NewMemoryBroker()
+	bridge := New(kiz, broker)
+	return bridge, broker, &executed, server
+}
+
+func TestStart(t *testing.T) {
+	_, broker, _, server := getTestBridge(t)
+	defer server.Close()
+
+	payload, ok := broker.Retained("kizcool/io_1111-0000-4444_11784413/core:ClosureState")
+	assert.True(t, ok)
+	assert.Equal(t, "100", string(payload))
+	payload, _ = broker.Retained("kizcool/io_1111-0000-4444_11784413/availability")
+	assert.Equal(t, Online, string(payload))
+	payload, _ = broker.Retained("kizcool/status")
+	assert.Equal(t, Online, string(payload))
+
+	var tests = []struct {
+		topic string
+		want  map[string]interface{}
+	}{
+		{"homeassistant/cover/kizcool_io_1111-0000-4444_22222222/config", map[string]interface{}{
+			"device_class":       "shutter",
+			"command_topic":      "kizcool/io_1111-0000-4444_22222222/set",
+			"position_topic":     "kizcool/io_1111-0000-4444_22222222/core:ClosureState",
+			"set_position_topic": "kizcool/io_1111-0000-4444_22222222/set",
+		}},
+		{"homeassistant/cover/kizcool_io_1111-0000-4444_11784413/config", map[string]interface{}{
+			"device_class": "window",
+			"state_topic":  "kizcool/io_1111-0000-4444_11784413/core:OpenClosedState",
+		}},
+		{"homeassistant/light/kizcool_io_1111-0000-4444_13523721/config", map[string]interface{}{
+			"brightness_command_template": "setIntensity {{ value }}",
+			"brightness_state_topic":      "kizcool/io_1111-0000-4444_13523721/core:LightIntensityState",
+			"brightness_scale":            float64(100),
+		}},
+		{"homeassistant/alarm_control_panel/kizcool_internal_1111-0000-4444_alarm_0/config", map[string]interface{}{
+			"payload_arm_away": "alarmOn",
+			"state_topic":      "kizcool/internal_1111-0000-4444_alarm_0/internal:CurrentAlarmModeState",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			payload, ok := broker.Retained(tt.topic)
+			assert.True(t, ok)
+			var config map[string]interface{}
+			assert.NoError(t, json.Unmarshal(payload, &config))
+			for k, v := range tt.want {
+				assert.Equal(t, v, config[k], k)
+			}
+		})
+	}
+	_, ok = broker.Retained("homeassistant/light/kizcool_internal_1111-0000-4444_pod_0/config")
+	assert.False(t, ok)
+}
+
+func TestHandle(t *testing.T) {
+	bridge, broker, _, server := getTestBridge(t)
+	defer server.Close()
+	assert.NoError(t, bridge.Handle(&kizcool.DeviceStateChangedEvent{
+		DeviceURL:    "io://1111-0000-4444/11784413",
+		DeviceStates: []kizcool.DeviceState{{Name: "core:OpenClosedState", Type: kizcool.StateString, Value: "open"}},
+	}))
+	payload, _ := broker.Retained("kizcool/io_1111-0000-4444_11784413/core:OpenClosedState")
+	assert.Equal(t, "open", string(payload))
+
+	assert.NoError(t, bridge.Handle(&kizcool.DeviceUnavailableEvent{
+		DeviceAvailabilityEvent: kizcool.DeviceAvailabilityEvent{DeviceURL: "io://1111-0000-4444/11784413"},
+	}))
+	payload, _ = broker.Retained("kizcool/io_1111-0000-4444_11784413/availability")
+	assert.Equal(t, Offline, string(payload))
+}
+
+func TestSet(t *testing.T) {
+	_, broker, executed, server := getTestBridge(t)
+	defer server.Close()
+	assert.NoError(t, broker.Publish("kizcool/io_1111-0000-4444_22222222/set", []byte("setClosure 30"), false))
+	assert.NoError(t, broker.Publish("kizcool/io_1111-0000-4444_13523721/set", []byte(`{"name": "on"}`), false))
+	// unsupported command and unknown device are ignored
+	assert.NoError(t, broker.Publish("kizcool/io_1111-0000-4444_13523721/set", []byte("open"), false))
+	assert.NoError(t, broker.Publish("kizcool/io_unknown/set", []byte("open"), false))
+
+	assert.Len(t, *executed, 2)
+	assert.Equal(t, kizcool.DeviceURL("io://1111-0000-4444/22222222"), (*executed)[0].Actions[0].DeviceURL)
+	assert.Equal(t, kizcool.Command{Name: "setClosure", Parameters: []interface{}{float64(30)}}, (*executed)[0].Actions[0].Commands[0])
+	assert.Equal(t, "on", (*executed)[1].Actions[0].Commands[0].Name)
+}
+
+func TestSetRetained(t *testing.T) {
+	bridge, broker, executed, server := getTestBridgeNotStarted(t)
+	defer server.Close()
+	assert.NoError(t, broker.Publish("kizcool/io_1111-0000-4444_22222222/set", []byte("open"), true))
+	assert.NoError(t, bridge.Start())
+	assert.Empty(t, *executed)
+}
+
+func TestParseCommand(t *testing.T) {
+	c, err := ParseCommand([]byte(" open\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, kizcool.Command{Name: "open"}, c)
+	c, err = ParseCommand([]byte("setName kitchen"))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"kitchen"}, c.Parameters)
+	_, err = ParseCommand([]byte(""))
+	assert.Error(t, err)
+	_, err = ParseCommand([]byte(`{"parameters": [1]}`))
+	assert.Error(t, err)
+}
+
+func TestTopicMatch(t *testing.T) {
+	assert.True(t, TopicMatch("kizcool/+/set", "kizcool/abc/set"))
+	assert.False(t, TopicMatch("kizcool/+/set", "kizcool/abc/def/set"))
+	assert.True(t, TopicMatch("kizcool/#", "kizcool/abc/def"))
+	assert.False(t, TopicMatch("kizcool/abc", "kizcool/abc/def"))
+}
@@ -0,0 +1,112 @@
+package mqttbridge
+
+import (
+	"strings"
+	"sync"
+)
+
+// Handler is called with the topic and payload of each received message. Retained is true
+// for the retained messages delivered when subscribing.
+type Handler func(topic string, payload []byte, retained bool)
+
+// Broker is the part of an mqtt client used by the bridge
+type Broker interface {
+	Publish(topic string, payload []byte, retained bool) error
+	Subscribe(filter string, handler Handler) error
+}
+
+// Message is a message published on a MemoryBroker
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// MemoryBroker is an in-process broker, delivering messages synchronously to subscribers.
+// It keeps retained messages and the list of all published messages. It is meant for tests.
+type MemoryBroker struct {
+	mux           sync.Mutex
+	subscriptions []subscription
+	retained      map[string][]byte
+	published     []Message
+}
+
+type subscription struct {
+	filter  string
+	handler Handler
+}
+
+// NewMemoryBroker returns an empty in-process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{retained: make(map[string][]byte)}
+}
+
+// Publish delivers the message to matching subscribers
+func (b *MemoryBroker) Publish(topic string, payload []byte, retained bool) error {
+	b.mux.Lock()
+	b.published = append(b.published, Message{Topic: topic, Payload: payload, Retained: retained})
+	if retained {
+		b.retained[topic] = payload
+	}
+	var handlers []Handler
+	for _, s := range b.subscriptions {
+		if TopicMatch(s.filter, topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	b.mux.Unlock()
+	for _, h := range handlers {
+		h(topic, payload, false)
+	}
+	return nil
+}
+
+// Subscribe registers the handler for topics matching the filter and delivers matching retained messages
+func (b *MemoryBroker) Subscribe(filter string, handler Handler) error {
+	b.mux.Lock()
+	b.subscriptions = append(b.subscriptions, subscription{filter, handler})
+	var retained []Message
+	for topic, payload := range b.retained {
+		if TopicMatch(filter, topic) {
+			retained = append(retained, Message{Topic: topic, Payload: payload, Retained: true})
+		}
+	}
+	b.mux.Unlock()
+	for _, m := range retained {
+		handler(m.Topic, m.Payload, true)
+	}
+	return nil
+}
+
+// Retained returns the last retained payload of the topic
+func (b *MemoryBroker) Retained(topic string) ([]byte, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+// Published returns all the messages published so far
+func (b *MemoryBroker) Published() []Message {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]Message{}, b.published...)
+}
+
+// TopicMatch tells if the topic matches the filter, which may contain the + and # wildcards
+func TopicMatch(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,137 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// coverClasses maps the UI class of covers to Home Assistant device classes
+var coverClasses = map[string]string{
+	"Awning":                "awning",
+	"Curtain":               "curtain",
+	"ExteriorScreen":        "shade",
+	"ExteriorVenetianBlind": "blind",
+	"GarageDoor":            "garage",
+	"Gate":                  "gate",
+	"Pergola":               "awning",
+	"RollerShutter":         "shutter",
+	"Screen":                "shade",
+	"Shutter":               "shutter",
+	"VenetianBlind":         "blind",
+	"Window":                "window",
+}
+
+// alarmStateTemplate maps TSK alarm modes to Home Assistant alarm states
+const alarmStateTemplate = `{{ {'off': 'disarmed', 'total': 'armed_away', 'partial1': 'armed_home', 'partial2': 'armed_night'}[value] | default('unknown') }}`
+
+// Discovery returns the Home Assistant component and config payload for the device.
+// ok is false for devices without a matching component.
+func (b *Bridge) Discovery(d kizcool.Device) (component string, config map[string]interface{}, ok bool) {
+	id := DeviceID(d.DeviceURL)
+	config = map[string]interface{}{
+		"name":          nil,
+		"unique_id":     "kizcool_" + id,
+		"object_id":     d.Label,
+		"command_topic": b.deviceTopic(d, "set"),
+		"availability": []map[string]string{
+			{"topic": b.StatusTopic()},
+			{"topic": b.deviceTopic(d, "availability")},
+		},
+		"availability_mode": "all",
+		"device": map[string]interface{}{
+			"identifiers":  []string{"kizcool_" + id},
+			"name":         d.Label,
+			"manufacturer": "Overkiz",
+			"model":        d.ControllableName,
+		},
+	}
+	supports := func(name string) bool { return kizcool.SupportsCommand(d, kizcool.Command{Name: name}) }
+	hasState := func(name kizcool.StateName) bool {
+		for _, s := range d.States {
+			if s.Name == name {
+				return true
+			}
+		}
+		for _, s := range d.Definition.States {
+			if s.QualifiedName == string(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case strings.Contains(d.ControllableName, "TSKAlarm"):
+		component = "alarm_control_panel"
+		config["state_topic"] = b.deviceTopic(d, "internal:CurrentAlarmModeState")
+		config["value_template"] = alarmStateTemplate
+		config["payload_disarm"] = "alarmOff"
+		config["payload_arm_away"] = "alarmOn"
+		config["payload_arm_home"] = "alarmPartial1"
+		config["payload_arm_night"] = "alarmPartial2"
+		config["supported_features"] = []string{"arm_home", "arm_away", "arm_night"}
+		config["code_arm_required"] = false
+		config["code_disarm_required"] = false
+	case coverClasses[d.UIClass] != "" && supports(kizcool.CmdOpen) && supports(kizcool.CmdClose):
+		component = "cover"
+		config["device_class"] = coverClasses[d.UIClass]
+		config["payload_open"] = kizcool.CmdOpen
+		config["payload_close"] = kizcool.CmdClose
+		if supports(kizcool.CmdStop) {
+			config["payload_stop"] = kizcool.CmdStop
+		} else {
+			config["payload_stop"] = nil
+		}
+		if hasState("core:OpenClosedState") {
+			config["state_topic"] = b.deviceTopic(d, "core:OpenClosedState")
+			config["state_open"] = "open"
+			config["state_closed"] = "closed"
+		}
+		// overkiz closure is 0 when open, Home Assistant position is 100 when open
+		if hasState("core:ClosureState") {
+			config["position_topic"] = b.deviceTopic(d, "core:ClosureState")
+			config["position_template"] = "{{ 100 - (value | int) }}"
+		}
+		if supports(kizcool.CmdSetClosure) {
+			config["set_position_topic"] = b.deviceTopic(d, "set")
+			config["set_position_template"] = kizcool.CmdSetClosure + " {{ 100 - position }}"
+		}
+	case d.UIClass == "Light" && supports(kizcool.CmdOn) && supports(kizcool.CmdOff):
+		component = "light"
+		config["payload_on"] = kizcool.CmdOn
+		config["payload_off"] = kizcool.CmdOff
+		if hasState("core:OnOffState") {
+			config["state_topic"] = b.deviceTopic(d, "core:OnOffState")
+		}
+		if supports(kizcool.CmdSetIntensity) {
+			config["brightness_command_topic"] = b.deviceTopic(d, "set")
+			config["brightness_command_template"] = kizcool.CmdSetIntensity + " {{ value }}"
+			config["brightness_scale"] = 100
+			if hasState("core:LightIntensityState") {
+				config["brightness_state_topic"] = b.deviceTopic(d, "core:LightIntensityState")
+			}
+		}
+	default:
+		return "", nil, false
+	}
+	return component, config, true
+}
+
+// discoveryTopic returns the topic of the discovery config of the device
+func (b *Bridge) discoveryTopic(component string, d kizcool.Device) string {
+	return b.DiscoveryPrefix + "/" + component + "/kizcool_" + DeviceID(d.DeviceURL) + "/config"
+}
+
+func (b *Bridge) publishDiscovery(d kizcool.Device) error {
+	component, config, ok := b.Discovery(d)
+	if !ok {
+		return nil
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return b.broker.Publish(b.discoveryTopic(component, d), payload, true)
+}
@@ -0,0 +1,30 @@
+package mqttbridge
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// pahoBroker adapts a paho mqtt client to the Broker interface
+type pahoBroker struct {
+	client mqtt.Client
+	qos    byte
+}
+
+// NewPahoBroker returns a Broker using a connected paho client, publishing and subscribing with qos 1
+func NewPahoBroker(client mqtt.Client) Broker {
+	return &pahoBroker{client: client, qos: 1}
+}
+
+func (b *pahoBroker) Publish(topic string, payload []byte, retained bool) error {
+	token := b.client.Publish(topic, b.qos, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (b *pahoBroker) Subscribe(filter string, handler Handler) error {
+	token := b.client.Subscribe(filter, b.qos, func(_ mqtt.Client, m mqtt.Message) {
+		handler(m.Topic(), m.Payload(), m.Retained())
+	})
+	token.Wait()
+	return token.Error()
+}
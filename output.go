@@ -1,28 +1,254 @@
 package kizcool
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"unicode/utf8"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 // Output prints the given object to the writer in the desired format
-// format can be 'text', 'json' or 'yaml'
+// format can be 'text', 'json', 'yaml', 'table', 'wide', 'csv', 'template=<go template>'
+// or 'jsonpath=<expression>'. The table and csv formats accept a list of columns
+// after '=', e.g. 'table=Label,DeviceURL,core:ClosureState'.
+// Slices are printed one item per row or line in the table, csv, template and jsonpath formats.
 func Output(w io.Writer, format string, obj interface{}) error {
-	switch format {
+	name, arg := format, ""
+	if i := strings.Index(format, "="); i >= 0 {
+		name, arg = format[:i], format[i+1:]
+	}
+	switch name {
 	case "yaml":
 		return printYAML(w, obj)
 	case "json":
 		return printJSON(w, obj)
 	case "text":
 		return printText(w, obj)
+	case "table":
+		return printTable(w, obj, splitColumns(arg), false)
+	case "wide":
+		return printTable(w, obj, splitColumns(arg), true)
+	case "csv":
+		return printCSV(w, obj, splitColumns(arg))
+	case "template":
+		return printTemplate(w, obj, arg)
+	case "jsonpath":
+		return printJSONPath(w, obj, arg)
 	default:
 		return fmt.Errorf("Unknown output format: %s", format)
 	}
 }
 
+// splitColumns splits a comma separated list of columns
+func splitColumns(arg string) []string {
+	var cols []string
+	for _, c := range strings.Split(arg, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// items returns the elements of obj if it is a slice, or obj itself otherwise
+func items(obj interface{}) []interface{} {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []interface{}{obj}
+	}
+	result := make([]interface{}, v.Len())
+	for i := range result {
+		result[i] = v.Index(i).Interface()
+	}
+	return result
+}
+
+// rows returns the header and the rows of cells for obj. Default columns are used if cols is empty.
+func rows(obj interface{}, cols []string, wide bool) ([]string, [][]string) {
+	list := items(obj)
+	if len(cols) == 0 {
+		if len(list) > 0 {
+			cols = columnsFor(list[0], wide)
+		} else {
+			cols = columnsFor(reflect.Zero(reflect.TypeOf(obj).Elem()).Interface(), wide)
+		}
+	}
+	var header []string
+	for _, c := range cols {
+		header = append(header, strings.ToUpper(c))
+	}
+	var cells [][]string
+	for _, item := range list {
+		var row []string
+		for _, c := range cols {
+			row = append(row, columnValue(item, c))
+		}
+		cells = append(cells, row)
+	}
+	return header, cells
+}
+
+// printTable prints aligned columns with a header
+func printTable(w io.Writer, obj interface{}, cols []string, wide bool) error {
+	header, cells := rows(obj, cols, wide)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+	for _, row := range cells {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// printCSV prints comma separated values with a header
+func printCSV(w io.Writer, obj interface{}, cols []string) error {
+	header, cells := rows(obj, cols, false)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(cells); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// Printer prints a stream of objects in the same format, e.g. events as they are received.
+// The table and csv headers are printed once, before the first object, and the columns of the
+// first object are used for all of them. Table columns are widened as longer values come.
+type Printer struct {
+	w       io.Writer
+	format  string
+	name    string
+	cols    []string
+	widths  []int
+	started bool
+}
+
+// NewPrinter returns a printer to the writer in the given format, see Output for the formats
+func NewPrinter(w io.Writer, format string) *Printer {
+	name, arg := format, ""
+	if i := strings.Index(format, "="); i >= 0 {
+		name, arg = format[:i], format[i+1:]
+	}
+	return &Printer{w: w, format: format, name: name, cols: splitColumns(arg)}
+}
+
+// Print prints the object, or each element of a slice
+func (p *Printer) Print(obj interface{}) error {
+	switch p.name {
+	case "table", "wide", "csv":
+	default:
+		return Output(p.w, p.format, obj)
+	}
+	list := items(obj)
+	if len(list) == 0 {
+		return nil
+	}
+	if len(p.cols) == 0 {
+		p.cols = columnsFor(list[0], p.name == "wide")
+	}
+	header, cells := rows(obj, p.cols, false)
+	if !p.started {
+		cells = append([][]string{header}, cells...)
+		p.started = true
+	}
+	if p.name == "csv" {
+		cw := csv.NewWriter(p.w)
+		if err := cw.WriteAll(cells); err != nil {
+			return err
+		}
+		return cw.Error()
+	}
+	for _, row := range cells {
+		for i, cell := range row {
+			if i >= len(p.widths) {
+				p.widths = append(p.widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > p.widths[i] {
+				p.widths[i] = n
+			}
+		}
+	}
+	for _, row := range cells {
+		if err := p.printRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printRow prints a table row padded to the column widths
+func (p *Printer) printRow(row []string) error {
+	var b strings.Builder
+	for i, cell := range row {
+		b.WriteString(cell)
+		if i < len(row)-1 {
+			b.WriteString(strings.Repeat(" ", p.widths[i]-utf8.RuneCountInString(cell)+2))
+		}
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
+
+// printTemplate executes the go template for each item, adding a new line if needed
+func printTemplate(w io.Writer, obj interface{}, text string) error {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, item := range items(obj) {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, item); err != nil {
+			return err
+		}
+		out := sb.String()
+		if !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		if _, err := io.WriteString(w, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printJSONPath prints the values selected by the jsonpath expression for each item, one per line.
+// Strings are printed as-is, other values as json.
+func printJSONPath(w io.Writer, obj interface{}, path string) error {
+	for _, item := range items(obj) {
+		values, err := jsonPath(item, path)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				s = string(b)
+			}
+			if _, err := io.WriteString(w, s+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func printJSON(w io.Writer, obj interface{}) error {
 	j, err := json.Marshal(obj)
 	if err != nil {
@@ -45,56 +271,43 @@ func printYAML(w io.Writer, obj interface{}) error {
 }
 
 // printText prints the object most useful fields in readable text format
+// Types implementing TextPrinter are supported, as well as strings and slices of those.
 func printText(w io.Writer, obj interface{}) (err error) {
 	switch t := obj.(type) {
-	default:
-		return fmt.Errorf("printText does not support type %T", t)
+	case string:
+		_, err = io.WriteString(w, t)
+		return err
 	case []string:
-		ls := obj.([]string)
-		for _, s := range ls {
+		for _, s := range t {
 			if _, err = io.WriteString(w, s+"\n"); err != nil {
 				return err
 			}
 		}
-	case string:
-		s := obj.(string)
-		if _, err = io.WriteString(w, s); err != nil {
-			return err
+		return nil
+	case TextPrinter:
+		return t.PrintText(w)
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("printText does not support type %T", obj)
+	}
+	for _, item := range items(obj) {
+		tp, ok := item.(TextPrinter)
+		if !ok {
+			return fmt.Errorf("printText does not support type %T", item)
 		}
-	case Device:
-		err := printTextDevice(w, obj.(Device))
-		if err != nil {
+		if err := tp.PrintText(w); err != nil {
 			return err
 		}
-	case []Device:
-		for _, d := range obj.([]Device) {
-			err := printTextDevice(w, d)
-			if err != nil {
-				return err
-			}
-		}
 	}
 	return nil
 }
 
-// printTextDevice prints useful values of a single device
-func printTextDevice(w io.Writer, d Device) (err error) {
-	wantedStates := map[StateName]bool{
-		"core:ClosureState":        true,
-		"core:OpenClosedState":     true,
-		"core:LightIntensityState": true,
-		"core:OnOffState":          true,
-		// "core:RSSILevelState":      true,
-	}
-	var states []DeviceState
-	for _, state := range d.States {
-		if wantedStates[state.Name] {
-			states = append(states, state)
-		}
-	}
-	if _, err = io.WriteString(w, fmt.Sprintf("| %-22s | %-33s | %v\n",
-		d.Label, d.DeviceURL, states)); err != nil {
-		return err
-	}
-	return nil
+// wantedStates are the states shown when printing a device as text
+var wantedStates = map[StateName]bool{
+	"core:ClosureState":        true,
+	"core:OpenClosedState":     true,
+	"core:LightIntensityState": true,
+	"core:OnOffState":          true,
+	// "core:RSSILevelState":      true,
 }
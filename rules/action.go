@@ -0,0 +1,99 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// PreparedAction is an action with resolved devices or scenario, ready to run
+type PreparedAction struct {
+	Text        string // description for logs
+	ActionGroup kizcool.ActionGroup
+	ScenarioOID string // empty for commands
+}
+
+// Prepare resolves the devices of the action in the setup, or its scenario by label or OID,
+// and checks that the devices support the command or that the scenario exists.
+func (a Action) Prepare(setup kizcool.Setup, scenarios []kizcool.ActionGroup) (PreparedAction, error) {
+	switch {
+	case a.Scenario != "" && a.Command == "":
+		var oid string
+		for _, ag := range scenarios {
+			if ag.OID == a.Scenario || (oid == "" && strings.EqualFold(ag.Label, a.Scenario)) {
+				oid = ag.OID
+			}
+		}
+		if oid == "" {
+			return PreparedAction{}, fmt.Errorf("unknown scenario %q", a.Scenario)
+		}
+		return PreparedAction{Text: "scenario " + a.Scenario, ScenarioOID: oid}, nil
+	case a.Command != "" && a.Scenario == "":
+		devices, err := kizcool.DevicesFromSetupByText(a.Device, setup)
+		if err != nil {
+			return PreparedAction{}, fmt.Errorf("action device %q: %w", a.Device, err)
+		}
+		command := kizcool.Command{Name: a.Command}
+		if len(a.Parameters) > 0 {
+			command.Parameters = a.Parameters
+		}
+		ag, err := kizcool.ActionGroupWithCommand(devices, command)
+		if err != nil {
+			return PreparedAction{}, err
+		}
+		text := fmt.Sprintf("%s %s", a.Command, a.Device)
+		if len(a.Parameters) > 0 {
+			text = fmt.Sprintf("%s %v %s", a.Command, a.Parameters, a.Device)
+		}
+		return PreparedAction{Text: text, ActionGroup: ag}, nil
+	default:
+		return PreparedAction{}, fmt.Errorf("an action needs either a command or a scenario")
+	}
+}
+
+// Run executes the action
+func (p PreparedAction) Run(exec Executor) error {
+	var err error
+	if p.ScenarioOID != "" {
+		_, err = exec.ExecuteActionGroup(p.ScenarioOID)
+	} else {
+		_, err = exec.Execute(p.ActionGroup)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Text, err)
+	}
+	return nil
+}
+
+// Actions are the prepared actions of a rule, or of a scheduled job, run in order
+type Actions []PreparedAction
+
+// Texts returns the descriptions of the actions
+func (as Actions) Texts() []string {
+	var texts []string
+	for _, a := range as {
+		texts = append(texts, a.Text)
+	}
+	return texts
+}
+
+// Fire runs the actions until one fails, or only logs them in dry run. The rule or job firing them
+// is logged with kind as key, e.g. "rule", and name as value.
+func (as Actions) Fire(exec Executor, dryRun bool, logger api.Logger, kind, name string) error {
+	title := strings.ToUpper(kind[:1]) + kind[1:]
+	actions := strings.Join(as.Texts(), ", ")
+	if dryRun {
+		logger.Info(title+" would fire (dry run)", kind, name, "actions", actions)
+		return nil
+	}
+	logger.Info(title+" fired", kind, name, "actions", actions)
+	for _, a := range as {
+		if err := a.Run(exec); err != nil {
+			logger.Error(title+" failed", kind, name, "actions", actions, "err", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package rules
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the content of a rules file
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule runs its actions when its trigger fires and all its conditions hold
+type Rule struct {
+	Name string      `yaml:"name"`
+	When Trigger     `yaml:"when"`
+	If   []Condition `yaml:"if"`
+	Do   []Action    `yaml:"do"`
+	// Debounce is how long the trigger must hold before the rule fires
+	Debounce time.Duration `yaml:"debounce"`
+	// Cooldown is the minimum time between two firings of the rule
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// Trigger fires on a state change, a gateway going up or down, or at a time of the day.
+// Exactly one of State, Gateway or At must be given.
+type Trigger struct {
+	Device string  `yaml:"device"` // url, label or selector of the devices whose state is watched
+	State  string  `yaml:"state"`  // state name, e.g. core:OpenClosedState
+	Op     string  `yaml:"op"`     // comparison operator, see kizcool.StateCompare, = by default
+	Value  *string `yaml:"value"`  // any change of the state fires if not given
+	// Gateway is up or down
+	Gateway string `yaml:"gateway"`
+	// At is a local time of the day, as HH:MM
+	At string `yaml:"at"`
+}
+
+// Condition holds when the cached state of all the devices compares to the value,
+// and the current time is between After and Before. Empty fields are not checked.
+type Condition struct {
+	Device string `yaml:"device"`
+	State  string `yaml:"state"`
+	Op     string `yaml:"op"`
+	Value  string `yaml:"value"`
+	After  string `yaml:"after"`  // HH:MM
+	Before string `yaml:"before"` // HH:MM, may be earlier than After to span midnight
+}
+
+// Action runs a command on devices or a scenario
+type Action struct {
+	Device     string        `yaml:"device"` // url, label or selector
+	Command    string        `yaml:"command"`
+	Parameters []interface{} `yaml:"parameters"`
+	Scenario   string        `yaml:"scenario"` // label or OID of an action group
+}
+
+// Load reads the rules from yaml
+func Load(r io.Reader) (Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("Error parsing rules: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadFile reads the rules from a yaml file
+func LoadFile(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid time %q, expecting HH:MM", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("Invalid time %q, expecting HH:MM", s)
+	}
+	return h*60 + m, nil
+}
@@ -0,0 +1,391 @@
+// Package rules runs automations declared in yaml: when a trigger fires and conditions hold,
+// commands or scenarios are executed.
+//
+//	rules:
+//	  - name: close the shutter when the window opens at night
+//	    when: {device: Fenetre1, state: core:OpenClosedState, value: open}
+//	    if:
+//	      - {after: "22:00", before: "07:00"}
+//	      - {device: Volet1, state: core:ClosureState, op: "<", value: 100}
+//	    do:
+//	      - {device: Volet1, command: close}
+//	    debounce: 30s
+//	    cooldown: 10m
+//	  - name: morning
+//	    when: {at: "07:30"}
+//	    do:
+//	      - {scenario: Reveil}
+//	  - name: gateway lost
+//	    when: {gateway: down}
+//	    do:
+//	      - {device: Spot1, command: on}
+//
+// Engines are fed events with Handle and the passing of time with Tick, so that rules
+// can be tested with synthetic events.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Executor runs actions, it is implemented by kizcool.Client
+type Executor interface {
+	Execute(kizcool.ActionGroup) (kizcool.ExecID, error)
+	ExecuteActionGroup(oid string) (kizcool.ExecID, error)
+}
+
+// Firing reports that a rule fired
+type Firing struct {
+	Rule    string
+	Time    time.Time
+	Actions []string
+	DryRun  bool
+	Err     error
+}
+
+// Engine evaluates rules. It is safe for concurrent use.
+type Engine struct {
+	// DryRun logs the rules that would fire without executing their actions
+	DryRun bool
+
+	exec   Executor
+	cache  *kizcool.StateCache
+	logger api.Logger
+
+	mux      sync.Mutex
+	rules    []*rule
+	lastTick time.Time
+}
+
+// rule is a Rule with resolved devices and scenarios
+type rule struct {
+	Rule
+	devices    map[kizcool.DeviceURL]bool
+	state      kizcool.StateName
+	predicate  kizcool.StatePredicate // nil for any change
+	at         int                    // minutes since midnight, -1 if not a time trigger
+	conditions []condition
+	actions    Actions
+
+	pending   time.Time // debounce deadline
+	lastFired time.Time
+}
+
+type condition struct {
+	urls          []kizcool.DeviceURL
+	state         kizcool.StateName
+	predicate     kizcool.StatePredicate
+	after, before int // -1 if not given
+}
+
+// Option configures an Engine, see New
+type Option func(*Engine)
+
+// WithLogger makes the engine log the rules that fire and their errors to l.
+func WithLogger(l api.Logger) Option {
+	return func(e *Engine) {
+		if l == nil {
+			l = api.NopLogger{}
+		}
+		e.logger = l
+	}
+}
+
+// New returns an engine for the rules. Devices are resolved in the setup and scenarios in the
+// action groups, so that errors in the rules are reported early.
+func New(cfg Config, setup kizcool.Setup, scenarios []kizcool.ActionGroup, exec Executor, opts ...Option) (*Engine, error) {
+	e := Engine{
+		exec:   exec,
+		cache:  kizcool.NewStateCache(setup),
+		logger: api.NopLogger{},
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		compiled, err := compile(r, setup, scenarios)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return &e, nil
+}
+
+// Cache returns the state cache used to evaluate conditions
+func (e *Engine) Cache() *kizcool.StateCache {
+	return e.cache
+}
+
+func compile(r Rule, setup kizcool.Setup, scenarios []kizcool.ActionGroup) (*rule, error) {
+	c := rule{Rule: r, at: -1}
+	w := r.When
+	triggers := 0
+	if w.State != "" {
+		triggers++
+		devices, err := kizcool.DevicesFromSetupByText(w.Device, setup)
+		if err != nil {
+			return nil, fmt.Errorf("trigger device %q: %w", w.Device, err)
+		}
+		c.devices = make(map[kizcool.DeviceURL]bool)
+		for _, d := range devices {
+			c.devices[d.DeviceURL] = true
+		}
+		c.state = kizcool.StateName(w.State)
+		if w.Value != nil {
+			if c.predicate, err = compare(w.Op, *w.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if w.Gateway != "" {
+		triggers++
+		if w.Gateway != "up" && w.Gateway != "down" {
+			return nil, fmt.Errorf("gateway trigger must be up or down, not %q", w.Gateway)
+		}
+	}
+	if w.At != "" {
+		triggers++
+		var err error
+		if c.at, err = parseClock(w.At); err != nil {
+			return nil, err
+		}
+	}
+	if triggers != 1 {
+		return nil, fmt.Errorf("when must have exactly one of state, gateway or at")
+	}
+
+	for _, cond := range r.If {
+		compiled := condition{after: -1, before: -1}
+		if cond.State != "" {
+			devices, err := kizcool.DevicesFromSetupByText(cond.Device, setup)
+			if err != nil {
+				return nil, fmt.Errorf("condition device %q: %w", cond.Device, err)
+			}
+			for _, d := range devices {
+				compiled.urls = append(compiled.urls, d.DeviceURL)
+			}
+			compiled.state = kizcool.StateName(cond.State)
+			if compiled.predicate, err = compare(cond.Op, cond.Value); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if cond.After != "" {
+			if compiled.after, err = parseClock(cond.After); err != nil {
+				return nil, err
+			}
+		}
+		if cond.Before != "" {
+			if compiled.before, err = parseClock(cond.Before); err != nil {
+				return nil, err
+			}
+		}
+		c.conditions = append(c.conditions, compiled)
+	}
+
+	if len(r.Do) == 0 {
+		return nil, fmt.Errorf("no action")
+	}
+	for _, a := range r.Do {
+		prepared, err := a.Prepare(setup, scenarios)
+		if err != nil {
+			return nil, err
+		}
+		c.actions = append(c.actions, prepared)
+	}
+	return &c, nil
+}
+
+// compare returns the state predicate, with = as default operator
+func compare(op, value string) (kizcool.StatePredicate, error) {
+	if op == "" {
+		op = "="
+	}
+	return kizcool.StateCompare(op, value)
+}
+
+// Handle updates the state cache with the event and fires the rules it triggers
+func (e *Engine) Handle(ev kizcool.Event, now time.Time) []Firing {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.cache.Update(ev)
+	var firings []Firing
+	for _, r := range e.rules {
+		matches, ok := r.triggeredBy(ev)
+		if !ok {
+			continue
+		}
+		switch {
+		case !matches:
+			r.pending = time.Time{}
+		case r.Debounce > 0:
+			if r.pending.IsZero() {
+				r.pending = now.Add(r.Debounce)
+			}
+		default:
+			if f, ok := e.fire(r, now); ok {
+				firings = append(firings, f)
+			}
+		}
+	}
+	return firings
+}
+
+// triggeredBy tells if the event concerns the trigger of the rule (ok) and if it matches
+func (r *rule) triggeredBy(ev kizcool.Event) (matches, ok bool) {
+	switch t := ev.(type) {
+	case *kizcool.DeviceStateChangedEvent:
+		if r.state == "" || !r.devices[t.DeviceURL] {
+			return false, false
+		}
+		for _, s := range t.DeviceStates {
+			if s.Name == r.state {
+				return r.predicate == nil || r.predicate(s), true
+			}
+		}
+	case *kizcool.GatewayDownEvent:
+		if r.When.Gateway != "" {
+			return r.When.Gateway == "down", true
+		}
+	case *kizcool.GatewayAliveEvent:
+		if r.When.Gateway != "" {
+			return r.When.Gateway == "up", true
+		}
+	}
+	return false, false
+}
+
+// holds tells if the trigger still matches the cached states, after a debounce
+func (e *Engine) holds(r *rule) bool {
+	switch {
+	case r.state != "":
+		if r.predicate == nil {
+			return true
+		}
+		for url := range r.devices {
+			if s, ok := e.cache.State(url, r.state); ok && r.predicate(s) {
+				return true
+			}
+		}
+		return false
+	case r.When.Gateway != "":
+		for _, alive := range e.cache.Gateways() {
+			if alive == (r.When.Gateway == "up") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Tick fires the time triggers reached since the previous tick and the rules whose debounce has elapsed
+func (e *Engine) Tick(now time.Time) []Firing {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	var firings []Firing
+	for _, r := range e.rules {
+		fire := false
+		if !r.pending.IsZero() && !now.Before(r.pending) {
+			r.pending = time.Time{}
+			fire = e.holds(r)
+		}
+		if r.at >= 0 && !e.lastTick.IsZero() && clockReached(e.lastTick, now, r.at) {
+			fire = true
+		}
+		if fire {
+			if f, ok := e.fire(r, now); ok {
+				firings = append(firings, f)
+			}
+		}
+	}
+	e.lastTick = now
+	return firings
+}
+
+// clockReached tells if the time of the day, in minutes, is in (from, to]
+func clockReached(from, to time.Time, minutes int) bool {
+	for day := from.In(to.Location()); !day.After(to.Add(24 * time.Hour)); day = day.Add(24 * time.Hour) {
+		y, m, d := day.Date()
+		t := time.Date(y, m, d, minutes/60, minutes%60, 0, 0, to.Location())
+		if t.After(from) && !t.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// fire runs the actions of the rule if its conditions hold and it is not cooling down
+func (e *Engine) fire(r *rule, now time.Time) (Firing, bool) {
+	if r.Cooldown > 0 && !r.lastFired.IsZero() && now.Sub(r.lastFired) < r.Cooldown {
+		return Firing{}, false
+	}
+	for _, c := range r.conditions {
+		if !e.check(c, now) {
+			return Firing{}, false
+		}
+	}
+	r.lastFired = now
+	f := Firing{Rule: r.Name, Time: now, Actions: r.actions.Texts(), DryRun: e.DryRun}
+	f.Err = r.actions.Fire(e.exec, e.DryRun, e.logger, "rule", r.Name)
+	return f, true
+}
+
+// check tells if the condition holds
+func (e *Engine) check(c condition, now time.Time) bool {
+	for _, url := range c.urls {
+		s, ok := e.cache.State(url, c.state)
+		if !ok || !c.predicate(s) {
+			return false
+		}
+	}
+	minutes := now.Hour()*60 + now.Minute()
+	switch {
+	case c.after >= 0 && c.before >= 0 && c.after > c.before:
+		// the window spans midnight
+		return minutes >= c.after || minutes < c.before
+	case c.after >= 0 && minutes < c.after:
+		return false
+	case c.before >= 0 && minutes >= c.before:
+		return false
+	}
+	return true
+}
+
+// Run feeds the engine with the events and ticks every second until the context is done
+// or the events channel is closed
+func (e *Engine) Run(ctx context.Context, events <-chan kizcool.Event) error {
+	return RunTicker(ctx, events, func(ev kizcool.Event, now time.Time) { e.Handle(ev, now) },
+		func(now time.Time) { e.Tick(now) })
+}
+
+// RunTicker calls tick now and every second, and handle with each event and the time it is received,
+// until the context is done or the events channel is closed. The events channel may be nil.
+func RunTicker(ctx context.Context, events <-chan kizcool.Event, handle func(kizcool.Event, time.Time), tick func(time.Time)) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	tick(time.Now())
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			handle(ev, time.Now())
+		case now := <-ticker.C:
+			tick(now)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
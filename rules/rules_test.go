@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+	"github.com/sgrimee/kizcool/kiztest"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupFile is the setup the tests run against
+var setupFile = filepath.Join("..", "testdata", "getSetup.json")
+
+func helperEngine(t *testing.T, yaml string) (*Engine, *kiztest.Executor) {
+	cfg, err := Load(strings.NewReader(yaml))
+	assert.NoError(t, err)
+	exec := &kiztest.Executor{}
+	scenarios := []kizcool.ActionGroup{{Label: "Reveil", OID: "1234"}}
+	e, err := New(cfg, kiztest.LoadSetup(t, setupFile), scenarios, exec)
+	assert.NoError(t, err)
+	return e, exec
+}
+
+func stateEvent(url kizcool.DeviceURL, name kizcool.StateName, value interface{}) kizcool.Event {
+	return &kizcool.DeviceStateChangedEvent{
+		DeviceURL:    url,
+		DeviceStates: []kizcool.DeviceState{{Name: name, Type: kizcool.StateString, Value: value}},
+	}
+}
+
+const (
+	fenetre1 = kizcool.DeviceURL("io://1111-0000-4444/11111111")
+	volet1   = kizcool.DeviceURL("io://1111-0000-4444/22222222")
+)
+
+var night = time.Date(2019, 11, 20, 23, 0, 0, 0, time.Local)
+
+func TestStateTrigger(t *testing.T) {
+	e, exec := helperEngine(t, `
+rules:
+  - name: close shutter
+    when: {device: Fenetre1, state: core:OpenClosedState, value: open}
+    if:
+      - {after: "22:00", before: "07:00"}
+      - {device: Volet1, state: core:ClosureState, op: "<", value: 100}
+    do:
+      - {device: Volet1, command: close}
+    cooldown: 10m
+`)
+	assert.Empty(t, e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "closed"), night))
+	assert.Empty(t, e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "open"), night.Add(-4*time.Hour)), "outside of the time window")
+	firings := e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "open"), night)
+	assert.Len(t, firings, 1)
+	assert.Equal(t, []string{"close Volet1"}, firings[0].Actions)
+	assert.Len(t, exec.Executed(), 1)
+	assert.Equal(t, volet1, exec.Executed()[0].Actions[0].DeviceURL)
+
+	assert.Empty(t, e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "open"), night.Add(5*time.Minute)), "cooling down")
+	e.Handle(&kizcool.DeviceStateChangedEvent{DeviceURL: volet1, DeviceStates: []kizcool.DeviceState{
+		{Name: "core:ClosureState", Type: kizcool.StateInt, Value: 100}}}, night)
+	assert.Empty(t, e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "open"), night.Add(20*time.Minute)), "the shutter is closed")
+	assert.Len(t, exec.Executed(), 1)
+}
+
+func TestDebounce(t *testing.T) {
+	e, exec := helperEngine(t, `
+rules:
+  - when: {device: "class=Window", state: core:OpenClosedState, value: open}
+    do:
+      - {device: Spots Nils, command: on}
+    debounce: 30s
+`)
+	e.Tick(night)
+	assert.Empty(t, e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "open"), night))
+	assert.Empty(t, e.Tick(night.Add(20*time.Second)))
+	e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "closed"), night.Add(25*time.Second))
+	assert.Empty(t, e.Tick(night.Add(40*time.Second)), "the window closed before the end of the debounce")
+
+	e.Handle(stateEvent(fenetre1, "core:OpenClosedState", "open"), night.Add(time.Minute))
+	firings := e.Tick(night.Add(2 * time.Minute))
+	assert.Len(t, firings, 1)
+	assert.Equal(t, "rule 1", firings[0].Rule)
+	assert.Len(t, exec.Executed(), 1)
+}
+
+func TestTimeAndGatewayTriggers(t *testing.T) {
+	e, exec := helperEngine(t, `
+rules:
+  - name: morning
+    when: {at: "07:30"}
+    do:
+      - {scenario: reveil}
+  - name: gateway lost
+    when: {gateway: down}
+    do:
+      - {device: Spots Nils, command: on}
+`)
+	e.DryRun = true
+	assert.Empty(t, e.Tick(night))
+	assert.Empty(t, e.Tick(night.Add(8*time.Hour)))
+	firings := e.Tick(night.Add(8*time.Hour + 31*time.Minute))
+	assert.Len(t, firings, 1)
+	assert.True(t, firings[0].DryRun)
+	assert.Empty(t, exec.Scenarios(), "dry run")
+
+	e.DryRun = false
+	assert.Empty(t, e.Handle(&kizcool.GatewayAliveEvent{}, night))
+	assert.Len(t, e.Handle(&kizcool.GatewayDownEvent{}, night), 1)
+	assert.Len(t, exec.Executed(), 1)
+	assert.Len(t, e.Tick(night.Add(32*time.Hour+31*time.Minute)), 1)
+	assert.Equal(t, []string{"1234"}, exec.Scenarios())
+}
+
+func TestRuleErrors(t *testing.T) {
+	for _, yaml := range []string{
+		`rules: [{when: {at: "25:00"}, do: [{scenario: reveil}]}]`,
+		`rules: [{when: {at: "07:00", gateway: up}, do: [{scenario: reveil}]}]`,
+		`rules: [{when: {gateway: sideways}, do: [{scenario: reveil}]}]`,
+		`rules: [{when: {device: nothing, state: core:OnOffState}, do: [{scenario: reveil}]}]`,
+		`rules: [{when: {at: "07:00"}}]`,
+		`rules: [{when: {at: "07:00"}, do: [{device: Fenetre1, command: on}]}]`,
+		`rules: [{when: {at: "07:00"}, do: [{device: Fenetre1}]}]`,
+		`rules: [{when: {at: "07:00"}, do: [{scenario: reveill}]}]`,
+		`rules: [{when: {device: Fenetre1, state: core:ClosureState, op: "<", value: open}, do: [{scenario: reveil}]}]`,
+	} {
+		cfg, err := Load(strings.NewReader(yaml))
+		assert.NoError(t, err, yaml)
+		_, err = New(cfg, kiztest.LoadSetup(t, setupFile), []kizcool.ActionGroup{{Label: "Reveil", OID: "1234"}}, &kiztest.Executor{})
+		assert.Error(t, err, yaml)
+	}
+	_, err := Load(strings.NewReader(`rules: [{unknown: field}]`))
+	assert.Error(t, err)
+}
+
+func TestPrepareScenario(t *testing.T) {
+	scenarios := []kizcool.ActionGroup{{Label: "Reveil", OID: "1234"}}
+	for _, text := range []string{"Reveil", "reveil", "1234"} {
+		p, err := Action{Scenario: text}.Prepare(kizcool.Setup{}, scenarios)
+		assert.NoError(t, err, text)
+		assert.Equal(t, "1234", p.ScenarioOID, text)
+	}
+	_, err := Action{Scenario: "Reveill"}.Prepare(kizcool.Setup{}, scenarios)
+	assert.Error(t, err)
+}
+
+func TestRunClosedEvents(t *testing.T) {
+	e, _ := helperEngine(t, `rules: [{when: {gateway: down}, do: [{scenario: reveil}]}]`)
+	events := make(chan kizcool.Event)
+	close(events)
+	assert.NoError(t, e.Run(context.Background(), events))
+}
+
+// testLogger records the info messages and their arguments
+type testLogger struct {
+	api.NopLogger
+	messages []string
+}
+
+func (l *testLogger) Info(msg string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(msg, args))
+}
+
+func TestWithLogger(t *testing.T) {
+	cfg, err := Load(strings.NewReader(`rules: [{name: lost, when: {gateway: down}, do: [{scenario: reveil}]}]`))
+	assert.NoError(t, err)
+	logger := &testLogger{}
+	scenarios := []kizcool.ActionGroup{{Label: "Reveil", OID: "1234"}}
+	e, err := New(cfg, kiztest.LoadSetup(t, setupFile), scenarios, &kiztest.Executor{}, WithLogger(logger))
+	assert.NoError(t, err)
+	e.Handle(&kizcool.GatewayDownEvent{}, night)
+	assert.Equal(t, []string{"Rule fired[rule lost actions scenario reveil]"}, logger.messages)
+}
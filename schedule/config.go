@@ -0,0 +1,82 @@
+package schedule
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sgrimee/kizcool/rules"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the content of a schedule file
+type Config struct {
+	// Latitude and Longitude are used for solar events, they default to the location of the setup
+	Latitude  *float64 `yaml:"latitude"`
+	Longitude *float64 `yaml:"longitude"`
+	// Timezone is an IANA name, it defaults to the timezone of the setup then to the local one
+	Timezone string `yaml:"timezone"`
+	Jobs     []Job  `yaml:"jobs"`
+}
+
+// Job runs its actions at the times given by a cron expression or a solar event.
+// Exactly one of Cron or Sun must be given.
+type Job struct {
+	Name string `yaml:"name"`
+	// Cron is a standard 5 fields cron expression or a descriptor such as @daily
+	Cron string `yaml:"cron"`
+	// Sun is sunrise, sunset, dawn or dusk (civil twilight), with an optional offset
+	// such as sunrise+30m or dusk-1h
+	Sun string `yaml:"sun"`
+	// Random shifts each firing by a random duration between -Random and +Random
+	Random time.Duration  `yaml:"random"`
+	Do     []rules.Action `yaml:"do"`
+}
+
+// Load reads the schedule from yaml
+func Load(r io.Reader) (Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("Error parsing schedule: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadFile reads the schedule from a yaml file
+func LoadFile(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// parseSun parses a solar event with an optional offset, e.g. sunrise+30m
+func parseSun(s string) (SunEvent, time.Duration, error) {
+	name, offset := s, ""
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		name, offset = s[:i], s[i:]
+	}
+	event := SunEvent(strings.TrimSpace(name))
+	switch event {
+	case Sunrise, Sunset, Dawn, Dusk:
+	default:
+		return "", 0, fmt.Errorf("Invalid solar event %q, expecting sunrise, sunset, dawn or dusk", s)
+	}
+	if offset == "" {
+		return event, 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(offset))
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid offset in solar event %q: %w", s, err)
+	}
+	return event, d, nil
+}
@@ -0,0 +1,198 @@
+package schedule
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/kiztest"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupFile is the setup the tests run against
+var setupFile = filepath.Join("..", "testdata", "getSetup.json")
+
+func helperScheduler(t *testing.T, yaml string) (*Scheduler, *kiztest.Executor) {
+	cfg, err := Load(strings.NewReader(yaml))
+	assert.NoError(t, err)
+	exec := &kiztest.Executor{}
+	scenarios := []kizcool.ActionGroup{{Label: "Evening", OID: "1234"}}
+	s, err := New(cfg, kiztest.LoadSetup(t, setupFile), scenarios, exec)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s.rand = rand.New(rand.NewSource(1))
+	return s, exec
+}
+
+func TestSunTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	assert.NoError(t, err)
+	day := time.Date(2020, 6, 21, 0, 0, 0, 0, paris)
+	tests := []struct {
+		event SunEvent
+		want  string
+	}{
+		{Sunrise, "05:47"},
+		{Sunset, "21:58"},
+		{Dawn, "05:03"},
+		{Dusk, "22:42"},
+	}
+	for _, tt := range tests {
+		got, ok := SunTime(tt.event, day, 48.8566, 2.3522)
+		assert.True(t, ok)
+		want, _ := time.ParseInLocation("2006-01-02 15:04", "2020-06-21 "+tt.want, paris)
+		assert.InDelta(t, 0, got.Sub(want).Minutes(), 3, "%s: got %v", tt.event, got)
+	}
+	// midnight sun in Tromsø
+	_, ok := SunTime(Sunset, day, 69.65, 18.96)
+	assert.False(t, ok)
+}
+
+func TestParseSun(t *testing.T) {
+	event, offset, err := parseSun("sunrise+30m")
+	assert.NoError(t, err)
+	assert.Equal(t, Sunrise, event)
+	assert.Equal(t, 30*time.Minute, offset)
+	event, offset, err = parseSun("dusk-1h")
+	assert.NoError(t, err)
+	assert.Equal(t, Dusk, event)
+	assert.Equal(t, -time.Hour, offset)
+	_, _, err = parseSun("noon")
+	assert.Error(t, err)
+	_, _, err = parseSun("sunset+soon")
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"no trigger", "jobs: [{do: [{scenario: Evening}]}]"},
+		{"both triggers", "jobs: [{cron: '0 7 * * *', sun: sunset, do: [{scenario: Evening}]}]"},
+		{"bad cron", "jobs: [{cron: '0 25 * * *', do: [{scenario: Evening}]}]"},
+		{"no action", "jobs: [{cron: '0 7 * * *'}]"},
+		{"unknown device", "jobs: [{cron: '0 7 * * *', do: [{device: Nope, command: open}]}]"},
+		{"bad timezone", "timezone: Mars/Olympus\njobs: [{cron: '0 7 * * *', do: [{scenario: Evening}]}]"},
+	}
+	for _, tt := range tests {
+		cfg, err := Load(strings.NewReader(tt.yaml))
+		assert.NoError(t, err, tt.name)
+		_, err = New(cfg, kiztest.LoadSetup(t, setupFile), nil, &kiztest.Executor{})
+		assert.Error(t, err, tt.name)
+	}
+	// solar events need a position
+	cfg, err := Load(strings.NewReader("jobs: [{sun: sunset, do: [{scenario: Evening}]}]"))
+	assert.NoError(t, err)
+	_, err = New(cfg, kizcool.Setup{}, nil, &kiztest.Executor{})
+	assert.Error(t, err)
+}
+
+func TestNext(t *testing.T) {
+	s, _ := helperScheduler(t, `
+timezone: Europe/Paris
+latitude: 48.8566
+longitude: 2.3522
+jobs:
+  - name: morning
+    cron: "0 7 * * 1-5"
+    random: 10m
+    do:
+      - {device: Volet1, command: open}
+  - name: evening
+    sun: sunset+30m
+    do:
+      - {scenario: Evening}
+`)
+	from := time.Date(2020, 6, 19, 12, 0, 0, 0, s.Location()) // friday
+	firings := s.Next(from, 4)
+	if assert.Len(t, firings, 4) {
+		assert.Equal(t, "evening", firings[0].Job)
+		assert.Equal(t, "evening", firings[1].Job)
+		assert.Equal(t, "evening", firings[2].Job)
+		assert.Equal(t, "morning", firings[3].Job)
+		assert.Equal(t, "2020-06-22 07:00", firings[3].Time.Format("2006-01-02 15:04"))
+		assert.Equal(t, 10*time.Minute, firings[3].Random)
+		assert.Equal(t, []string{"open Volet1"}, firings[3].Actions)
+		sunset, _ := SunTime(Sunset, from, 48.8566, 2.3522)
+		assert.Equal(t, sunset.Add(30*time.Minute), firings[0].Time)
+		assert.Equal(t, 20, firings[1].Time.Day())
+		assert.Equal(t, 21, firings[2].Time.Day())
+	}
+}
+
+func TestTick(t *testing.T) {
+	s, exec := helperScheduler(t, `
+timezone: UTC
+jobs:
+  - name: morning
+    cron: "0 7 * * *"
+    random: 10m
+    do:
+      - {device: Volet1, command: open}
+  - name: evening
+    sun: dusk
+    do:
+      - {scenario: Evening}
+`)
+	start := time.Date(2020, 6, 19, 6, 0, 0, 0, time.UTC)
+	assert.Empty(t, s.Tick(start))
+	assert.Empty(t, s.Tick(start.Add(45*time.Minute)))
+
+	// the morning job fires once within its random window
+	var fired []time.Time
+	for now := start.Add(49 * time.Minute); now.Before(start.Add(2 * time.Hour)); now = now.Add(time.Second) {
+		for _, f := range s.Tick(now) {
+			assert.Equal(t, "morning", f.Job)
+			assert.NoError(t, f.Err)
+			fired = append(fired, f.Time)
+		}
+	}
+	if assert.Len(t, fired, 1) {
+		assert.WithinDuration(t, start.Add(time.Hour), fired[0], 10*time.Minute)
+	}
+	if assert.Len(t, exec.Executed(), 1) {
+		assert.Equal(t, "open", exec.Executed()[0].Actions[0].Commands[0].Name)
+	}
+
+	// the evening job fires at civil dusk of the setup location
+	dusk, ok := SunTime(Dusk, start, 42.357, 1.343)
+	assert.True(t, ok)
+	assert.Empty(t, s.Tick(dusk.Add(-time.Second)))
+	firings := s.Tick(dusk)
+	if assert.Len(t, firings, 1) {
+		assert.Equal(t, "evening", firings[0].Job)
+	}
+	assert.Equal(t, []string{"1234"}, exec.Scenarios())
+	assert.Empty(t, s.Tick(dusk.Add(time.Minute)))
+
+	// after a clock jump of several days, missed firings are not replayed
+	jump := start.Add(3*24*time.Hour + 6*time.Hour)
+	firings = s.Tick(jump)
+	assert.Len(t, firings, 2, "each job fires once")
+	for now := jump.Add(time.Second); now.Before(jump.Add(10 * time.Minute)); now = now.Add(time.Second) {
+		assert.Empty(t, s.Tick(now))
+	}
+}
+
+func TestTickDryRun(t *testing.T) {
+	s, exec := helperScheduler(t, `
+jobs:
+  - cron: "*/5 * * * *"
+    do:
+      - {scenario: Evening}
+`)
+	s.DryRun = true
+	start := time.Date(2020, 6, 19, 6, 1, 0, 0, time.Local)
+	s.Tick(start)
+	firings := s.Tick(start.Add(4 * time.Minute))
+	if assert.Len(t, firings, 1) {
+		assert.True(t, firings[0].DryRun)
+		assert.Equal(t, "job 1", firings[0].Job)
+	}
+	assert.Empty(t, exec.Scenarios())
+}
@@ -0,0 +1,282 @@
+// Package schedule runs commands and scenarios at times given by cron expressions
+// or solar events computed locally, with optional random offsets for presence simulation.
+//
+// Example schedule file:
+//
+//	timezone: Europe/Paris
+//	jobs:
+//	  - name: open shutters
+//	    cron: "0 7 * * 1-5"
+//	    random: 10m
+//	    do:
+//	      - device: class=RollerShutter
+//	        command: open
+//	  - name: evening
+//	    sun: dusk+15m
+//	    do:
+//	      - scenario: Evening
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+	"github.com/sgrimee/kizcool/rules"
+)
+
+// maxSunSearch is how many days to look ahead for a solar event, for polar regions
+const maxSunSearch = 370
+
+// Firing reports that a job fired, or will fire
+type Firing struct {
+	Job     string
+	Time    time.Time
+	Random  time.Duration // firings returned by Next may be shifted by up to this duration
+	Actions []string
+	DryRun  bool
+	Err     error
+}
+
+// PrintText prints the firing in human readable form
+func (f Firing) PrintText(w io.Writer) error {
+	when := f.Time.Format("Mon 2006-01-02 15:04:05 MST")
+	if f.Random > 0 {
+		when += fmt.Sprintf(" ±%v", f.Random)
+	}
+	_, err := fmt.Fprintf(w, "%s  %s: %s\n", when, f.Job, strings.Join(f.Actions, ", "))
+	return err
+}
+
+type job struct {
+	Job
+	cron    cron.Schedule
+	sun     SunEvent
+	offset  time.Duration
+	actions rules.Actions
+	base    time.Time // next firing without random offset
+	planned time.Time // next firing, zero until planned
+	never   bool      // no next firing
+}
+
+// Scheduler runs the jobs of a schedule. It is safe for concurrent use.
+type Scheduler struct {
+	// DryRun logs the jobs that would fire without executing their actions
+	DryRun bool
+
+	mux       sync.Mutex
+	jobs      []*job
+	exec      rules.Executor
+	loc       *time.Location
+	latitude  float64
+	longitude float64
+	rand      *rand.Rand
+	logger    api.Logger
+}
+
+// Option configures a Scheduler, see New
+type Option func(*Scheduler)
+
+// WithLogger makes the scheduler log planned and fired jobs and their errors to l.
+func WithLogger(l api.Logger) Option {
+	return func(s *Scheduler) {
+		if l == nil {
+			l = api.NopLogger{}
+		}
+		s.logger = l
+	}
+}
+
+// New checks and compiles the schedule. Devices and scenarios of actions are resolved in the
+// setup and scenarios, the position and timezone default to those of the setup.
+func New(cfg Config, setup kizcool.Setup, scenarios []kizcool.ActionGroup, exec rules.Executor, opts ...Option) (*Scheduler, error) {
+	s := &Scheduler{
+		exec:      exec,
+		loc:       time.Local,
+		latitude:  setup.Location.Latitude,
+		longitude: setup.Location.Longitude,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger:    api.NopLogger{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timezone: %w", err)
+		}
+		s.loc = loc
+	} else if setup.Location.Timezone != "" {
+		if loc, err := time.LoadLocation(setup.Location.Timezone); err == nil {
+			s.loc = loc
+		}
+	}
+	if cfg.Latitude != nil {
+		s.latitude = *cfg.Latitude
+	}
+	if cfg.Longitude != nil {
+		s.longitude = *cfg.Longitude
+	}
+	for i, j := range cfg.Jobs {
+		if j.Name == "" {
+			j.Name = fmt.Sprintf("job %d", i+1)
+		}
+		c, err := s.compile(j, setup, scenarios)
+		if err != nil {
+			return nil, fmt.Errorf("Job %q: %w", j.Name, err)
+		}
+		s.jobs = append(s.jobs, c)
+	}
+	return s, nil
+}
+
+func (s *Scheduler) compile(j Job, setup kizcool.Setup, scenarios []kizcool.ActionGroup) (*job, error) {
+	c := &job{Job: j}
+	switch {
+	case j.Cron != "" && j.Sun == "":
+		sched, err := cron.ParseStandard(j.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cron expression: %w", err)
+		}
+		if spec, ok := sched.(*cron.SpecSchedule); ok {
+			spec.Location = s.loc
+		}
+		c.cron = sched
+	case j.Sun != "" && j.Cron == "":
+		event, offset, err := parseSun(j.Sun)
+		if err != nil {
+			return nil, err
+		}
+		if s.latitude == 0 && s.longitude == 0 {
+			return nil, fmt.Errorf("Solar events need a latitude and longitude")
+		}
+		c.sun, c.offset = event, offset
+	default:
+		return nil, fmt.Errorf("A job needs either a cron expression or a solar event")
+	}
+	if j.Random < 0 {
+		return nil, fmt.Errorf("Random must be positive")
+	}
+	if len(j.Do) == 0 {
+		return nil, fmt.Errorf("A job needs at least one action")
+	}
+	for _, a := range j.Do {
+		prepared, err := a.Prepare(setup, scenarios)
+		if err != nil {
+			return nil, err
+		}
+		c.actions = append(c.actions, prepared)
+	}
+	return c, nil
+}
+
+// Location returns the timezone of the schedule
+func (s *Scheduler) Location() *time.Location {
+	return s.loc
+}
+
+// next returns the next time of the job strictly after the given time, without random offset.
+// It returns false if there is none, as for a solar event that does not happen at the position.
+func (s *Scheduler) next(j *job, after time.Time) (time.Time, bool) {
+	if j.cron != nil {
+		t := j.cron.Next(after)
+		return t, !t.IsZero()
+	}
+	local := after.In(s.loc)
+	// start the day before in case the offset moves the event across midnight
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 12, 0, 0, 0, s.loc)
+	for i := 0; i < maxSunSearch; i++ {
+		if t, ok := SunTime(j.sun, day, s.latitude, s.longitude); ok {
+			if t = t.Add(j.offset); t.After(after) {
+				return t, true
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}, false
+}
+
+// Next returns the next n firings after the given time, in order and without random offsets
+func (s *Scheduler) Next(after time.Time, n int) []Firing {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var firings []Firing
+	for _, j := range s.jobs {
+		t := after
+		for i := 0; i < n; i++ {
+			var ok bool
+			if t, ok = s.next(j, t); !ok {
+				break
+			}
+			firings = append(firings, Firing{Job: j.Name, Time: t.In(s.loc), Random: j.Random, Actions: j.actions.Texts(), DryRun: s.DryRun})
+		}
+	}
+	sort.SliceStable(firings, func(a, b int) bool { return firings[a].Time.Before(firings[b].Time) })
+	if len(firings) > n {
+		firings = firings[:n]
+	}
+	return firings
+}
+
+// plan sets the next firing of the job after the given time, with a random offset
+func (s *Scheduler) plan(j *job, after time.Time) {
+	base, ok := s.next(j, after)
+	if !ok {
+		j.never = true
+		s.logger.Warn("Job will never fire", "job", j.Name)
+		return
+	}
+	j.base, j.planned = base, base
+	if j.Random > 0 {
+		j.planned = base.Add(time.Duration(s.rand.Int63n(2*int64(j.Random)+1)) - j.Random)
+	}
+	s.logger.Debug("Job planned", "job", j.Name, "time", j.planned.In(s.loc))
+}
+
+// Tick runs the jobs whose planned time is reached and plans their next firing.
+// Jobs are planned after now on the first tick.
+func (s *Scheduler) Tick(now time.Time) []Firing {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var firings []Firing
+	for _, j := range s.jobs {
+		if j.planned.IsZero() && !j.never {
+			s.plan(j, now)
+			continue
+		}
+		if j.never || now.Before(j.planned) {
+			continue
+		}
+		firings = append(firings, s.fire(j, now))
+		// plan after the base time, which may be later than now with a negative random offset,
+		// or after now if firings were missed, e.g. during a suspend, so that they are not replayed
+		after := j.base
+		if now.After(after) {
+			after = now
+		}
+		s.plan(j, after)
+	}
+	return firings
+}
+
+// fire runs the actions of the job
+func (s *Scheduler) fire(j *job, now time.Time) Firing {
+	f := Firing{Job: j.Name, Time: now, Actions: j.actions.Texts(), DryRun: s.DryRun}
+	f.Err = j.actions.Fire(s.exec, s.DryRun, s.logger, "job", j.Name)
+	return f
+}
+
+// Run fires the jobs until the context is done
+func (s *Scheduler) Run(ctx context.Context) error {
+	return rules.RunTicker(ctx, nil, nil, func(now time.Time) { s.Tick(now) })
+}
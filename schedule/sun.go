@@ -0,0 +1,65 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+// SunEvent is a daily solar event
+type SunEvent string
+
+// Solar events. Dawn and Dusk are the start and end of civil twilight.
+const (
+	Sunrise SunEvent = "sunrise"
+	Sunset  SunEvent = "sunset"
+	Dawn    SunEvent = "dawn"
+	Dusk    SunEvent = "dusk"
+)
+
+// altitude of the sun center at the event, in degrees
+var sunAltitudes = map[SunEvent]float64{
+	Sunrise: -0.833, // refraction and solar disc radius
+	Sunset:  -0.833,
+	Dawn:    -6,
+	Dusk:    -6,
+}
+
+const (
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+	toRad           = math.Pi / 180
+)
+
+// SunTime returns the time of the solar event on the given day at the given position,
+// using the sunrise equation. The day is taken in the location of date.
+// It returns false if the event does not happen that day, as in polar day or night.
+func SunTime(event SunEvent, date time.Time, latitude, longitude float64) (time.Time, bool) {
+	altitude, ok := sunAltitudes[event]
+	if !ok {
+		return time.Time{}, false
+	}
+	y, m, d := date.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, date.Location())
+	// mean solar noon of the day at the longitude, in julian days since J2000
+	n := math.Round(float64(noon.Unix())/86400 + julianUnixEpoch - julian2000 + longitude/360)
+	j := n - longitude/360
+	meanAnomaly := math.Mod(357.5291+0.98560028*j, 360)
+	mr := meanAnomaly * toRad
+	center := 1.9148*math.Sin(mr) + 0.02*math.Sin(2*mr) + 0.0003*math.Sin(3*mr)
+	lambda := math.Mod(meanAnomaly+center+180+102.9372, 360) * toRad
+	transit := julian2000 + j + 0.0053*math.Sin(mr) - 0.0069*math.Sin(2*lambda)
+	sinDecl := math.Sin(lambda) * math.Sin(23.4397*toRad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	lat := latitude * toRad
+	cosHour := (math.Sin(altitude*toRad) - math.Sin(lat)*sinDecl) / (math.Cos(lat) * cosDecl)
+	if cosHour < -1 || cosHour > 1 {
+		return time.Time{}, false
+	}
+	hour := math.Acos(cosHour) / toRad / 360
+	jd := transit + hour
+	if event == Sunrise || event == Dawn {
+		jd = transit - hour
+	}
+	secs := (jd - julianUnixEpoch) * 86400
+	return time.Unix(0, int64(secs*1e9)).In(date.Location()).Round(time.Second), true
+}
@@ -0,0 +1,372 @@
+package kizcool
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Selector selects devices with an expression made of terms combined with and, or, not and parentheses.
+//
+// Supported terms are:
+//
+//	all                            every device
+//	label=Kitchen                  label, case insensitive
+//	label~"Living*"                label matching a glob pattern
+//	label~/^Living/                label matching a regular expression
+//	class=RollerShutter            UIClass of the device
+//	room=Bedroom                   place label or OID, including sub-places
+//	url~"io://*"                   device URL
+//	state:core:ClosureState>=50    state value, see StateCompare
+//
+// All fields accept the operators =, != and ~ (glob or /regexp/). States also accept <, <=, > and >=.
+//
+// Example: class=RollerShutter and (room=Bedroom or label~"Office*")
+type Selector struct {
+	expr string
+	root selectorNode
+}
+
+// selectorNode is a node of the parsed expression
+type selectorNode func(d Device, root Place) bool
+
+// ParseSelector compiles a selector expression
+func ParseSelector(expr string) (Selector, error) {
+	tokens, err := tokenizeSelector(expr)
+	if err != nil {
+		return Selector{}, err
+	}
+	p := selectorParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return Selector{}, err
+	}
+	if p.pos < len(p.tokens) {
+		return Selector{}, fmt.Errorf("Unexpected %q in selector", p.tokens[p.pos].text)
+	}
+	return Selector{expr: expr, root: node}, nil
+}
+
+// Match returns true if the device is selected.
+// root is the root place of the setup, used to find rooms by label. It can be empty,
+// in which case rooms can only be matched by OID.
+func (s Selector) Match(d Device, root Place) bool {
+	if s.root == nil {
+		return false
+	}
+	return s.root(d, root)
+}
+
+// String returns the original expression
+func (s Selector) String() string {
+	return s.expr
+}
+
+// Select returns the devices matching the selector expression.
+// Rooms can only be matched by OID, use SelectInSetup to match them by label.
+func Select(devices []Device, expr string) ([]Device, error) {
+	return selectWithPlace(devices, Place{}, expr)
+}
+
+// SelectInSetup returns the devices of the setup matching the selector expression
+func SelectInSetup(setup Setup, expr string) ([]Device, error) {
+	return selectWithPlace(setup.Devices, setup.RootPlace, expr)
+}
+
+func selectWithPlace(devices []Device, root Place, expr string) ([]Device, error) {
+	s, err := ParseSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+	var result []Device
+	for _, d := range devices {
+		if s.Match(d, root) {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+type selectorTokenKind int
+
+const (
+	tokWord selectorTokenKind = iota
+	tokString
+	tokRegexp
+	tokOperator
+	tokOpen
+	tokClose
+)
+
+type selectorToken struct {
+	kind selectorTokenKind
+	text string
+}
+
+// tokenizeSelector splits the expression into words, quoted strings, /regexps/, operators and parentheses
+func tokenizeSelector(expr string) ([]selectorToken, error) {
+	var tokens []selectorToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, selectorToken{tokOpen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, selectorToken{tokClose, ")"})
+			i++
+		case r == '"' || r == '\'' || (r == '/' && afterOperator(tokens)):
+			kind := tokString
+			if r == '/' {
+				kind = tokRegexp
+			}
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) && runes[j+1] == r {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("Unterminated %c in selector", r)
+			}
+			tokens = append(tokens, selectorToken{kind, sb.String()})
+			i = j + 1
+		case strings.ContainsRune("=!~<>", r):
+			j := i + 1
+			if j < len(runes) && runes[j] == '=' || (r == '!' && j < len(runes) && runes[j] == '~') {
+				j++
+			}
+			tokens = append(tokens, selectorToken{tokOperator, string(runes[i:j])})
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()=!~<>\"'", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, selectorToken{tokWord, string(runes[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// afterOperator returns true if the last token is an operator, meaning a value is expected
+func afterOperator(tokens []selectorToken) bool {
+	return len(tokens) > 0 && tokens[len(tokens)-1].kind == tokOperator
+}
+
+// selectorParser is a recursive descent parser for selector expressions
+type selectorParser struct {
+	tokens []selectorToken
+	pos    int
+}
+
+func (p *selectorParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokWord && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *selectorParser) parseOr() (selectorNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d Device, root Place) bool { return l(d, root) || right(d, root) }
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (selectorNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d Device, root Place) bool { return l(d, root) && right(d, root) }
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseUnary() (selectorNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected end of selector")
+	}
+	if p.peekKeyword("not") {
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(d Device, root Place) bool { return !n(d, root) }, nil
+	}
+	if p.tokens[p.pos].kind == tokOpen {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokClose {
+			return nil, fmt.Errorf("Missing ) in selector")
+		}
+		p.pos++
+		return n, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *selectorParser) parseTerm() (selectorNode, error) {
+	key := p.tokens[p.pos]
+	if key.kind != tokWord {
+		return nil, fmt.Errorf("Unexpected %q in selector", key.text)
+	}
+	p.pos++
+	if strings.EqualFold(key.text, "all") {
+		return func(Device, Place) bool { return true }, nil
+	}
+	if p.pos+1 >= len(p.tokens) || p.tokens[p.pos].kind != tokOperator {
+		return nil, fmt.Errorf("Expected field, operator and value in selector after %q", key.text)
+	}
+	op, value := p.tokens[p.pos].text, p.tokens[p.pos+1]
+	if value.kind == tokOperator || value.kind == tokOpen || value.kind == tokClose {
+		return nil, fmt.Errorf("Unexpected %q in selector", value.text)
+	}
+	p.pos += 2
+
+	field := strings.ToLower(key.text)
+	if strings.HasPrefix(field, "state:") {
+		return stateTerm(StateName(key.text[len("state:"):]), op, value)
+	}
+	var get func(d Device) []string
+	switch field {
+	case "label":
+		get = func(d Device) []string { return []string{d.Label} }
+	case "class":
+		get = func(d Device) []string { return []string{d.UIClass, d.Definition.UIClass} }
+	case "url":
+		get = func(d Device) []string { return []string{string(d.DeviceURL)} }
+	case "room":
+		return roomTerm(op, value)
+	default:
+		return nil, fmt.Errorf("Unknown field %q in selector", key.text)
+	}
+	match, err := stringMatcher(op, value)
+	if err != nil {
+		return nil, err
+	}
+	return func(d Device, _ Place) bool {
+		for _, s := range get(d) {
+			if s != "" && match(s) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// roomTerm matches devices in the place with the given label or OID, or one of its sub-places
+func roomTerm(op string, value selectorToken) (selectorNode, error) {
+	if op != "=" && op != "!=" {
+		match, err := stringMatcher(op, value)
+		if err != nil {
+			return nil, err
+		}
+		return func(d Device, root Place) bool {
+			if p, ok := root.Find(d.PlaceOID); ok {
+				return match(p.Label)
+			}
+			return match(d.PlaceOID)
+		}, nil
+	}
+	return func(d Device, root Place) bool {
+		in := d.PlaceOID == value.text
+		if place, ok := root.Find(value.text); ok {
+			in = place.Contains(d.PlaceOID)
+		}
+		return in == (op == "=")
+	}, nil
+}
+
+// stateTerm matches devices having the state with a value satisfying the operator
+func stateTerm(name StateName, op string, value selectorToken) (selectorNode, error) {
+	var predicate StatePredicate
+	if op == "~" || op == "!~" {
+		match, err := stringMatcher(op, value)
+		if err != nil {
+			return nil, err
+		}
+		predicate = func(s DeviceState) bool { return match(fmt.Sprint(s.Value)) }
+	} else {
+		var err error
+		if predicate, err = StateCompare(op, value.text); err != nil {
+			return nil, err
+		}
+	}
+	return func(d Device, _ Place) bool {
+		for _, s := range d.States {
+			if s.Name == name {
+				return predicate(s)
+			}
+		}
+		return false
+	}, nil
+}
+
+// stringMatcher returns a case insensitive comparison for the operators =, !=, ~ and !~
+// With ~, the value is a glob pattern, or a regular expression if given as /regexp/.
+func stringMatcher(op string, value selectorToken) (func(string) bool, error) {
+	var match func(string) bool
+	switch {
+	case op == "=" || op == "!=":
+		match = func(s string) bool { return strings.EqualFold(s, value.text) }
+	case (op == "~" || op == "!~") && value.kind == tokRegexp:
+		re, err := regexp.Compile("(?i)" + value.text)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	case op == "~" || op == "!~":
+		match = globRegexp(value.text).MatchString
+	default:
+		return nil, fmt.Errorf("Operator %s is only supported for states", op)
+	}
+	if strings.HasPrefix(op, "!") {
+		return func(s string) bool { return !match(s) }, nil
+	}
+	return match, nil
+}
+
+// globRegexp converts a case insensitive glob pattern, where * matches any text and ? any character, to a regexp
+func globRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
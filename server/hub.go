@@ -0,0 +1,53 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// subscriberBuffer is the number of events queued for a subscriber before events are dropped
+const subscriberBuffer = 64
+
+// Hub broadcasts events to any number of subscribers. Events are dropped for
+// subscribers that do not keep up, so that a slow client cannot block the others.
+type Hub struct {
+	mux         sync.Mutex
+	subscribers map[chan kizcool.Event]struct{}
+}
+
+// NewHub returns a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[chan kizcool.Event]struct{})}
+}
+
+// Subscribe returns a channel receiving all events published from now on
+func (h *Hub) Subscribe() chan kizcool.Event {
+	ch := make(chan kizcool.Event, subscriberBuffer)
+	h.mux.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mux.Unlock()
+	return ch
+}
+
+// Unsubscribe stops sending events to the channel and closes it
+func (h *Hub) Unsubscribe(ch chan kizcool.Event) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends the event to all subscribers
+func (h *Hub) Publish(e kizcool.Event) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
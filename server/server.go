@@ -0,0 +1,332 @@
+// Package server exposes a Kiz client as a small REST/JSON api for local clients.
+//
+// All clients share a single session with the api server, and device states are served
+// from a cache kept up to date with events.
+//
+//	GET  /devices                          all devices with their states
+//	GET  /devices/{device}/states          states of a device, by label or url
+//	POST /devices/{device}/commands/{name} run a command, the optional body is the json list of parameters
+//	GET  /scenarios                        action groups
+//	POST /scenarios/{oid}/run              run an action group
+//	GET  /events                           server-sent events, filtered with ?device= and ?event=
+//	GET  /events/ws                        websocket stream of events accepting commands, see EventStream
+//
+// The api key can also be given with ?api_key=, as browsers cannot set headers on websockets.
+//
+// Path segments must be url-escaped, e.g. /devices/io:%2F%2F1111-0000-4444%2F11784413/states.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Server serves the REST api
+type Server struct {
+	// APIKey is required from clients in the X-API-Key header or as a bearer token. No key is required if empty.
+	APIKey string
+
+	kiz    kizcool.Client
+	cache  *kizcool.StateCache
+	hub    *Hub
+	stream *EventStream
+	logger api.Logger
+}
+
+// Option configures a Server or an EventStream
+type Option func(*options)
+
+type options struct {
+	logger api.Logger
+}
+
+// WithLogger makes the server log polling, encoding and websocket errors to l.
+func WithLogger(l api.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// newOptions applies the options to the defaults
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = api.NopLogger{}
+	}
+	return o
+}
+
+// New returns a server with a state cache initialized from the current setup
+func New(kiz kizcool.Client, opts ...Option) (*Server, error) {
+	setup, err := kiz.GetSetup()
+	if err != nil {
+		return nil, err
+	}
+	s := Server{
+		kiz:    kiz,
+		cache:  kizcool.NewStateCache(setup),
+		hub:    NewHub(),
+		logger: newOptions(opts).logger,
+	}
+	s.stream = NewEventStream(kiz, s.hub, s.cache, opts...)
+	return &s, nil
+}
+
+// Hub returns the hub broadcasting the events received by Run
+func (s *Server) Hub() *Hub {
+	return s.hub
+}
+
+// EventStream returns the websocket handler served at /events/ws
+func (s *Server) EventStream() *EventStream {
+	return s.stream
+}
+
+// Cache returns the state cache of the server
+func (s *Server) Cache() *kizcool.StateCache {
+	return s.cache
+}
+
+// Run polls for events, updates the state cache and broadcasts events until the context is done.
+// Polling errors are logged and polling resumes after a pause.
+func (s *Server) Run(ctx context.Context) error {
+	for event := range kizcool.ListenEvents(ctx, s.kiz, s.logger) {
+		s.Update(event)
+	}
+	return ctx.Err()
+}
+
+// Update applies the event to the state cache and broadcasts it
+func (s *Server) Update(e kizcool.Event) {
+	s.cache.Update(e)
+	s.hub.Publish(e)
+}
+
+// httpError is an error with the http status to return
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(status int, format string, a ...interface{}) error {
+	return &httpError{status: status, err: fmt.Errorf(format, a...)}
+}
+
+// ServeHTTP routes the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeError(w, errorf(http.StatusUnauthorized, "Missing or invalid api key"))
+		return
+	}
+	path, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, errorf(http.StatusBadRequest, "Invalid path: %v", err))
+		return
+	}
+	route := func(method string, pattern ...string) bool {
+		if r.Method != method || len(path) != len(pattern) {
+			return false
+		}
+		for i, p := range pattern {
+			if p != "*" && p != path[i] {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case route(http.MethodGet, "devices"):
+		s.writeJSON(w, s.cache.Devices())
+	case route(http.MethodGet, "devices", "*", "states"):
+		d, err := s.device(path[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.writeJSON(w, d.States)
+	case route(http.MethodPost, "devices", "*", "commands", "*"):
+		s.command(w, r, path[1], path[3])
+	case route(http.MethodGet, "scenarios"):
+		ags, err := s.kiz.GetActionGroups()
+		if err != nil {
+			writeError(w, errorf(http.StatusBadGateway, "%v", err))
+			return
+		}
+		s.writeJSON(w, ags)
+	case route(http.MethodPost, "scenarios", "*", "run"):
+		id, err := s.kiz.ExecuteActionGroup(path[1])
+		if err != nil {
+			writeError(w, errorf(http.StatusBadGateway, "%v", err))
+			return
+		}
+		s.writeJSON(w, map[string]kizcool.ExecID{"execId": id})
+	case route(http.MethodGet, "events"):
+		s.events(w, r)
+	case route(http.MethodGet, "events", "ws"):
+		s.stream.ServeHTTP(w, r)
+	default:
+		writeError(w, errorf(http.StatusNotFound, "No route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// authorized checks the api key of the request
+func (s *Server) authorized(r *http.Request) bool {
+	if s.APIKey == "" {
+		return true
+	}
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(s.APIKey)) == 1
+}
+
+// splitPath returns the unescaped segments of the path
+func splitPath(escaped string) ([]string, error) {
+	var path []string
+	for _, p := range strings.Split(strings.Trim(escaped, "/"), "/") {
+		segment, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, segment)
+	}
+	return path, nil
+}
+
+// device finds a cached device by url or label
+func (s *Server) device(text string) (kizcool.Device, error) {
+	if d, ok := s.cache.Device(kizcool.DeviceURL(text)); ok {
+		return d, nil
+	}
+	d, err := kizcool.DeviceFromListByLabel(text, s.cache.Devices())
+	if lerr, ok := err.(*kizcool.LabelError); ok {
+		if lerr.Ambiguous {
+			return d, errorf(http.StatusConflict, "%v: %s", err, text)
+		}
+		return d, errorf(http.StatusNotFound, "%v: %s", err, text)
+	}
+	return d, err
+}
+
+// command runs a command on a device. The body is empty or the json list of parameters.
+func (s *Server) command(w http.ResponseWriter, r *http.Request, text, name string) {
+	d, err := s.device(text)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	command := kizcool.Command{Name: name}
+	var params []interface{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+		writeError(w, errorf(http.StatusBadRequest, "Invalid parameters, expecting a json list: %v", err))
+		return
+	}
+	if len(params) > 0 {
+		command.Parameters = params
+	}
+	ag, err := kizcool.ActionGroupWithOneCommand(d, command)
+	if err != nil {
+		writeError(w, errorf(http.StatusBadRequest, "%v: %s", err, name))
+		return
+	}
+	id, err := s.kiz.Execute(ag)
+	if err != nil {
+		writeError(w, errorf(http.StatusBadGateway, "%v", err))
+		return
+	}
+	s.writeJSON(w, map[string]kizcool.ExecID{"execId": id})
+}
+
+// filter returns the event filter given in the query, with ?device= and ?event=
+func (s *Server) filter(r *http.Request) (kizcool.EventFilter, error) {
+	query := r.URL.Query()
+	filter := kizcool.EventFilter{Names: query["event"]}
+	for _, text := range query["device"] {
+		d, err := s.device(text)
+		if err != nil {
+			return filter, err
+		}
+		filter.DeviceURLs = append(filter.DeviceURLs, d.DeviceURL)
+	}
+	return filter, nil
+}
+
+// events streams events as server-sent events until the client disconnects
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errorf(http.StatusInternalServerError, "Streaming is not supported"))
+		return
+	}
+	filter, err := s.filter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	events := s.hub.Subscribe()
+	defer s.hub.Unsubscribe(events)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			e, ok = filter.Filter(e)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.logger.Error("Error encoding event", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kizcool.EventName(e), data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		s.logger.Error("Error encoding response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if herr, ok := err.(*httpError); ok {
+		status = herr.status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
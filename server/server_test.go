@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestServer returns a server backed by a fake api server serving the test setup
+func getTestServer(t *testing.T) (*Server, *httptest.Server) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "getSetup.json"))
+	assert.NoError(t, err)
+	var fixture struct {
+		Setup json.RawMessage
+	}
+	assert.NoError(t, json.Unmarshal(data, &fixture))
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.String() {
+		case "/enduserAPI/setup":
+			rw.Write(fixture.Setup)
+		case "/enduserAPI/exec/apply":
+			var ag kizcool.ActionGroup
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&ag))
+			assert.Equal(t, kizcool.DeviceURL("io://1111-0000-4444/11111111"), ag.Actions[0].DeviceURL)
+			assert.Equal(t, kizcool.Command{Name: "setClosure", Parameters: []interface{}{float64(50)}}, ag.Actions[0].Commands[0])
+			rw.Write([]byte(`{"execId": "abc"}`))
+		case "/enduserAPI/exec/1234":
+			rw.Write([]byte(`{"execId": "def"}`))
+		default:
+			t.Errorf("Unexpected request %s", req.URL)
+		}
+	}))
+	ac, err := api.NewWithHTTPClient("", "", backend.URL, "", backend.Client())
+	assert.NoError(t, err)
+	kiz, _ := kizcool.NewWithAPIClient(ac)
+	srv, err := New(kiz)
+	assert.NoError(t, err)
+	srv.APIKey = "secret"
+	return srv, backend
+}
+
+func TestServer(t *testing.T) {
+	srv, backend := getTestServer(t)
+	defer backend.Close()
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		key    string
+		status int
+		want   string
+	}{
+		{"no key", "GET", "/devices", "", "", 401, "api key"},
+		{"bad key", "GET", "/devices", "", "bad", 401, "api key"},
+		{"devices", "GET", "/devices", "", "secret", 200, `"Label":"Fenetre1"`},
+		{"states by label", "GET", "/devices/fenetre1/states", "", "secret", 200, `"Name":"core:ClosureState"`},
+		{"states by url", "GET", "/devices/io:%2F%2F1111-0000-4444%2F11111111/states", "", "secret", 200, `"Name":"core:ClosureState"`},
+		{"unknown device", "GET", "/devices/nothing/states", "", "secret", 404, "No device with that label"},
+		{"command", "POST", "/devices/Fenetre1/commands/setClosure", "[50]", "secret", 200, `"execId":"abc"`},
+		{"unsupported command", "POST", "/devices/Fenetre1/commands/setIntensity", "[50]", "secret", 400, "does not support"},
+		{"bad parameters", "POST", "/devices/Fenetre1/commands/setClosure", "{", "secret", 400, "json list"},
+		{"scenario", "POST", "/scenarios/1234/run", "", "secret", 200, `"execId":"def"`},
+		{"no route", "DELETE", "/devices", "", "secret", 404, "No route"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.want)
+		})
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	srv, backend := getTestServer(t)
+	defer backend.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/events?device=Fenetre1", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the first event is filtered out, the second updates the cache and is streamed
+	srv.Update(&kizcool.DeviceStateChangedEvent{
+		GenericEvent: kizcool.GenericEvent{Name: "DeviceStateChangedEvent"},
+		DeviceURL:    "io://1111-0000-4444/22222222",
+	})
+	srv.Update(&kizcool.DeviceStateChangedEvent{
+		GenericEvent: kizcool.GenericEvent{Name: "DeviceStateChangedEvent"},
+		DeviceURL:    "io://1111-0000-4444/11111111",
+		DeviceStates: []kizcool.DeviceState{{Name: "core:ClosureState", Type: kizcool.StateInt, Value: 30}},
+	})
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: DeviceStateChangedEvent\n", line)
+	line, err = r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Contains(t, line, `"deviceURL":"io://1111-0000-4444/11111111"`)
+
+	s, ok := srv.Cache().State("io://1111-0000-4444/11111111", "core:ClosureState")
+	assert.True(t, ok)
+	assert.Equal(t, 30, s.Value)
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	ch := hub.Subscribe()
+	for i := 0; i < subscriberBuffer+1; i++ {
+		hub.Publish(&kizcool.GatewayAliveEvent{})
+	}
+	assert.Len(t, ch, subscriberBuffer, "events are dropped when the subscriber is full")
+	hub.Unsubscribe(ch)
+	hub.Unsubscribe(ch)
+	hub.Publish(&kizcool.GatewayAliveEvent{})
+}
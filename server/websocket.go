@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/api"
+)
+
+// Heartbeat settings of websocket connections
+const (
+	pingPeriod = 30 * time.Second
+	pongWait   = 60 * time.Second
+	writeWait  = 10 * time.Second
+)
+
+// ClientMessage is a message sent by a websocket client. Type is one of:
+//
+//	filter   only receive events matching Devices (urls or labels), Events (names) and States
+//	command  run Command on Device, the reply has the same ID
+type ClientMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Devices []string        `json:"devices,omitempty"`
+	Events  []string        `json:"events,omitempty"`
+	States  []string        `json:"states,omitempty"`
+	Device  string          `json:"device,omitempty"`
+	Command kizcool.Command `json:"command"`
+}
+
+// ServerMessage is a message sent to websocket clients. Type is one of:
+//
+//	event    Event is an event matching the filter of the client
+//	result   ExecID is the execution started by the command with the same ID
+//	error    Error tells why the message with the same ID failed
+type ServerMessage struct {
+	Type   string         `json:"type"`
+	ID     string         `json:"id,omitempty"`
+	Event  kizcool.Event  `json:"event,omitempty"`
+	ExecID kizcool.ExecID `json:"execId,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// EventStream is an http.Handler upgrading connections to websocket and streaming
+// the events of a hub as json ServerMessages. Clients can set filters and run
+// commands with ClientMessages. Connections are kept alive with pings.
+type EventStream struct {
+	// Upgrader upgrades the connections, set its CheckOrigin to accept other origins
+	Upgrader websocket.Upgrader
+
+	kiz    kizcool.Client
+	hub    *Hub
+	cache  *kizcool.StateCache
+	logger api.Logger
+}
+
+// NewEventStream returns a handler streaming the events of the hub. The cache is used
+// to find devices by label and to check commands before running them with kiz.
+func NewEventStream(kiz kizcool.Client, hub *Hub, cache *kizcool.StateCache, opts ...Option) *EventStream {
+	return &EventStream{kiz: kiz, hub: hub, cache: cache, logger: newOptions(opts).logger}
+}
+
+// ServeHTTP upgrades the connection and streams events until the client disconnects
+func (s *EventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied to the client
+		return
+	}
+	defer conn.Close()
+
+	events := s.hub.Subscribe()
+	defer s.hub.Unsubscribe(events)
+	filters := make(chan kizcool.EventFilter)
+	replies := make(chan ServerMessage, 8)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go s.read(conn, filters, replies, done, quit)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	var filter kizcool.EventFilter
+	for {
+		var msg ServerMessage
+		select {
+		case f := <-filters:
+			filter = f
+			continue
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e, ok = filter.Filter(e); !ok {
+				continue
+			}
+			msg = ServerMessage{Type: "event", Event: e}
+		case msg = <-replies:
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+			continue
+		case <-done:
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// read handles client messages until the connection fails, then closes done.
+// It also returns when quit is closed, once ServeHTTP stopped receiving filters and replies.
+func (s *EventStream) read(conn *websocket.Conn, filters chan<- kizcool.EventFilter, replies chan<- ServerMessage,
+	done chan<- struct{}, quit <-chan struct{}) {
+	defer close(done)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		var msg ClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.logger.Debug("Websocket read error", "err", err)
+			}
+			return
+		}
+		var reply ServerMessage
+		switch msg.Type {
+		case "filter":
+			f, err := s.filter(msg)
+			if err != nil {
+				reply = ServerMessage{Type: "error", ID: msg.ID, Error: err.Error()}
+				break
+			}
+			select {
+			case filters <- f:
+			case <-quit:
+				return
+			}
+			continue
+		case "command":
+			reply = s.command(msg)
+		default:
+			reply = ServerMessage{Type: "error", ID: msg.ID, Error: fmt.Sprintf("Unknown message type: %s", msg.Type)}
+		}
+		select {
+		case replies <- reply:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// device finds a cached device by url or label
+func (s *EventStream) device(text string) (kizcool.Device, error) {
+	if d, ok := s.cache.Device(kizcool.DeviceURL(text)); ok {
+		return d, nil
+	}
+	d, err := kizcool.DeviceFromListByLabel(text, s.cache.Devices())
+	if err != nil {
+		return d, fmt.Errorf("%v: %s", err, text)
+	}
+	return d, nil
+}
+
+func (s *EventStream) filter(msg ClientMessage) (kizcool.EventFilter, error) {
+	filter := kizcool.EventFilter{Names: msg.Events}
+	for _, text := range msg.Devices {
+		d, err := s.device(text)
+		if err != nil {
+			return filter, err
+		}
+		filter.DeviceURLs = append(filter.DeviceURLs, d.DeviceURL)
+	}
+	for _, name := range msg.States {
+		filter.States = append(filter.States, kizcool.StateName(name))
+	}
+	return filter, nil
+}
+
+func (s *EventStream) command(msg ClientMessage) ServerMessage {
+	reply := ServerMessage{Type: "error", ID: msg.ID}
+	d, err := s.device(msg.Device)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	ag, err := kizcool.ActionGroupWithOneCommand(d, msg.Command)
+	if err != nil {
+		reply.Error = fmt.Sprintf("%v: %s", err, msg.Command.Name)
+		return reply
+	}
+	id, err := s.kiz.Execute(ag)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	return ServerMessage{Type: "result", ID: msg.ID, ExecID: id}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sgrimee/kizcool"
+)
+
+func TestEventStream(t *testing.T) {
+	srv, backend := getTestServer(t)
+	defer backend.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	_, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/events/ws", nil)
+	assert.Error(t, err, "the api key is required")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/events/ws?api_key=secret", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// commands are replied with the execution id
+	assert.NoError(t, conn.WriteJSON(ClientMessage{Type: "command", ID: "1", Device: "Fenetre1",
+		Command: kizcool.Command{Name: "setClosure", Parameters: []interface{}{50}}}))
+	var reply ServerMessage
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Equal(t, ServerMessage{Type: "result", ID: "1", ExecID: "abc"}, reply)
+
+	assert.NoError(t, conn.WriteJSON(ClientMessage{Type: "command", ID: "2", Device: "nothing"}))
+	reply = ServerMessage{}
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, "2", reply.ID)
+
+	// the filter applies to the events published after it
+	assert.NoError(t, conn.WriteJSON(ClientMessage{Type: "filter", Devices: []string{"Fenetre1"}}))
+	assert.NoError(t, conn.WriteJSON(ClientMessage{Type: "bogus", ID: "3"}))
+	reply = ServerMessage{}
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Equal(t, "3", reply.ID, "messages are handled in order, so the filter is set")
+	srv.Update(&kizcool.GatewayAliveEvent{GenericEvent: kizcool.GenericEvent{Name: "GatewayAliveEvent"}})
+	srv.Update(&kizcool.DeviceStateChangedEvent{
+		GenericEvent: kizcool.GenericEvent{Name: "DeviceStateChangedEvent"},
+		DeviceURL:    "io://1111-0000-4444/11111111",
+	})
+	var msg struct {
+		Type  string
+		Event json.RawMessage
+	}
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "event", msg.Type)
+	assert.Contains(t, string(msg.Event), `"name":"DeviceStateChangedEvent"`)
+}
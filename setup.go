@@ -0,0 +1,90 @@
+package kizcool
+
+import "strings"
+
+// Setup describes the whole installation: location, gateways, devices and places
+type Setup struct {
+	CreationTime   int
+	LastUpdateTime int
+	ID             string
+	OID            string
+	Location       Location
+	Gateways       []Gateway
+	Devices        []Device
+	RootPlace      Place
+}
+
+// Location is the address and geographical position of the installation
+type Location struct {
+	City          string
+	Country       string
+	PostalCode    string
+	AddressLine1  string
+	AddressLine2  string
+	Timezone      string
+	Longitude     float64
+	Latitude      float64
+	TwilightMode  int
+	TwilightAngle string
+	TwilightCity  string
+	DawnOffset    int
+	DuskOffset    int
+}
+
+// Gateway is a box relaying commands to the devices, e.g. a Tahoma
+type Gateway struct {
+	GatewayID      string
+	Type           int
+	SubType        int
+	PlaceOID       string
+	Alive          bool
+	TimeReliable   bool
+	Connectivity   GatewayConnectivity
+	UpToDate       bool
+	UpdateStatus   string
+	SyncInProgress bool
+	Mode           string
+}
+
+// GatewayConnectivity describes the connection between the gateway and the server
+type GatewayConnectivity struct {
+	Status          string
+	ProtocolVersion string
+}
+
+// Place is a building, floor or room. Places form a tree from the root place of the setup.
+type Place struct {
+	CreationTime   int
+	LastUpdateTime int
+	Label          string
+	Type           int
+	OID            string
+	Metadata       string
+	SubPlaces      []Place
+}
+
+// Find returns the place or sub-place with the given OID or label (case insensitive)
+func (p Place) Find(text string) (Place, bool) {
+	if p.OID == text || (p.Label != "" && strings.EqualFold(p.Label, text)) {
+		return p, true
+	}
+	for _, sub := range p.SubPlaces {
+		if found, ok := sub.Find(text); ok {
+			return found, true
+		}
+	}
+	return Place{}, false
+}
+
+// Contains returns true if the place with given OID is this place or one of its sub-places
+func (p Place) Contains(oid string) bool {
+	if p.OID == oid {
+		return true
+	}
+	for _, sub := range p.SubPlaces {
+		if sub.Contains(oid) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// Measurement is the influx measurement of state changes
+const Measurement = "kizcool_state"
+
+// Influx writes state changes in the InfluxDB line protocol, one line per state:
+//
+//	kizcool_state,device=io://1111-0000-4444/11111111,state=core:ClosureState value=30 1574106269793000000
+//
+// Numeric states are written to the float field value and other states to the string field text,
+// so that a field always has the same type. Other events are ignored.
+type Influx struct {
+	w io.WriteCloser
+}
+
+// NewInflux returns a sink writing line protocol to w
+func NewInflux(w io.WriteCloser) *Influx {
+	return &Influx{w: w}
+}
+
+// Record writes the state changes of the event
+func (s *Influx) Record(e kizcool.Event) error {
+	lines := InfluxLines(e)
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(s.w, lines)
+	return err
+}
+
+// Close closes the writer
+func (s *Influx) Close() error {
+	return s.w.Close()
+}
+
+var (
+	tagEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// InfluxLines returns the line protocol of the state changes of the event, each line ending with a newline
+func InfluxLines(e kizcool.Event) string {
+	var b strings.Builder
+	for _, c := range kizcool.StateChanges(e) {
+		t := c.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		var field string
+		if f, ok := (kizcool.DeviceState{Type: c.Type, Value: c.Value}).Float(); ok && c.Type != kizcool.StateString {
+			field = "value=" + strconv.FormatFloat(f, 'f', -1, 64)
+		} else {
+			field = `text="` + stringEscaper.Replace(fmt.Sprint(c.Value)) + `"`
+		}
+		fmt.Fprintf(&b, "%s,device=%s,state=%s %s %d\n", Measurement,
+			tagEscaper.Replace(string(c.DeviceURL)), tagEscaper.Replace(string(c.Name)), field, t.UnixNano())
+	}
+	return b.String()
+}
+
+// InfluxHTTP posts state changes in the line protocol to an InfluxDB write endpoint,
+// e.g. http://localhost:8086/api/v2/write?org=home&bucket=kizcool
+type InfluxHTTP struct {
+	url   string
+	token string
+	hc    *http.Client
+}
+
+// NewInfluxHTTP returns a sink writing to the endpoint url. The token is optional.
+func NewInfluxHTTP(url, token string, hc *http.Client) *InfluxHTTP {
+	return &InfluxHTTP{url: url, token: token, hc: hc}
+}
+
+// Record posts the state changes of the event
+func (s *InfluxHTTP) Record(e kizcool.Event) error {
+	lines := InfluxLines(e)
+	if len(lines) == 0 {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewBufferString(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Error writing to influx: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close does nothing
+func (s *InfluxHTTP) Close() error {
+	return nil
+}
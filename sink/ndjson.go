@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sgrimee/kizcool"
+)
+
+// NDJSON writes events as json, one per line
+type NDJSON struct {
+	w io.WriteCloser
+}
+
+// NewNDJSON returns a sink writing to w
+func NewNDJSON(w io.WriteCloser) *NDJSON {
+	return &NDJSON{w: w}
+}
+
+// Record writes the event on one line
+func (s *NDJSON) Record(e kizcool.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the writer
+func (s *NDJSON) Close() error {
+	return s.w.Close()
+}
+
+// RotatingFile is a file that is rotated when it reaches a maximum size: path is renamed to path.1,
+// path.1 to path.2 and so on, keeping at most maxFiles old files. It is safe for concurrent use.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mux  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewRotatingFile opens the file at path for appending
+func NewRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	r := RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would not fit
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	name := func(i int) string { return fmt.Sprintf("%s.%d", r.path, i) }
+	if r.maxFiles > 0 {
+		os.Remove(name(r.maxFiles))
+		for i := r.maxFiles - 1; i >= 1; i-- {
+			if err := os.Rename(name(i), name(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(r.path, name(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close closes the file
+func (r *RotatingFile) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.f.Close()
+}
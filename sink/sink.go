@@ -0,0 +1,141 @@
+// Package sink provides implementations of kizcool.Sink writing events to files,
+// time series databases and SQLite.
+package sink
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/sgrimee/kizcool/history"
+)
+
+// Open returns the sink described by spec:
+//
+//	influx:-                                   line protocol to stdout
+//	influx:states.lp                           line protocol appended to a file
+//	influx://localhost:8086/write?db=home      line protocol posted to an http endpoint (influxs:// for https),
+//	                                           a token query parameter is sent as the Authorization token
+//	ndjson:-                                   events as json lines to stdout
+//	ndjson:events.json?max-size=10MB&max-files=5  json lines to a file, rotated at max-size
+//	sqlite:events.db                           events and states in a database, see the history package
+func Open(spec string) (kizcool.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sink %q: %w", spec, err)
+	}
+	path := u.Opaque
+	if path == "" {
+		path = u.Path
+	}
+	switch u.Scheme {
+	case "influx", "influxs":
+		if u.Host != "" {
+			scheme := "http"
+			if u.Scheme == "influxs" {
+				scheme = "https"
+			}
+			query := u.Query()
+			token := query.Get("token")
+			query.Del("token")
+			endpoint := url.URL{Scheme: scheme, Host: u.Host, Path: u.Path, RawQuery: query.Encode()}
+			return NewInfluxHTTP(endpoint.String(), token, &http.Client{Timeout: 10 * time.Second}), nil
+		}
+		w, err := openFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return NewInflux(w), nil
+	case "ndjson":
+		if path == "-" {
+			return NewNDJSON(stdout{}), nil
+		}
+		maxSize, err := parseSize(u.Query().Get("max-size"))
+		if err != nil {
+			return nil, err
+		}
+		maxFiles := 5
+		if s := u.Query().Get("max-files"); s != "" {
+			if maxFiles, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("Invalid max-files %q: %w", s, err)
+			}
+		}
+		f, err := NewRotatingFile(path, maxSize, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+		return NewNDJSON(f), nil
+	case "sqlite":
+		return history.Open(path)
+	default:
+		return nil, fmt.Errorf("Unknown sink %q, expecting influx:, ndjson: or sqlite:", spec)
+	}
+}
+
+// openFile opens the file for appending, or stdout for "-"
+func openFile(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return stdout{}, nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("Missing file name in sink")
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// stdout writes to the standard output and is not closed
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdout) Close() error                { return nil }
+
+// parseSize parses a size in bytes with an optional KB, MB or GB suffix. An empty size is 0.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	unit := int64(1)
+	for suffix, u := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSuffix(s, suffix), u
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q: %w", s, err)
+	}
+	return n * unit, nil
+}
+
+// Multi sends events to several sinks
+type Multi []kizcool.Sink
+
+// Record sends the event to all sinks and returns the first error
+func (m Multi) Record(e kizcool.Event) error {
+	var first error
+	for _, s := range m {
+		if err := s.Record(e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close closes all sinks and returns the first error
+func (m Multi) Close() error {
+	var first error
+	for _, s := range m {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
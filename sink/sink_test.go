@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgrimee/kizcool"
+	"github.com/stretchr/testify/assert"
+)
+
+var testEvent = &kizcool.DeviceStateChangedEvent{
+	GenericEvent: kizcool.GenericEvent{Name: "DeviceStateChangedEvent", Timestamp: 1574106269793},
+	DeviceURL:    "io://1111-0000-4444/11111111",
+	DeviceStates: []kizcool.DeviceState{
+		{Name: "core:ClosureState", Type: kizcool.StateInt, Value: float64(30)},
+		{Name: "core:NameState", Type: kizcool.StateString, Value: `Fenetre "sdb", enfants`},
+	},
+}
+
+func TestInfluxLines(t *testing.T) {
+	assert.Equal(t, `kizcool_state,device=io://1111-0000-4444/11111111,state=core:ClosureState value=30 1574106269793000000
+kizcool_state,device=io://1111-0000-4444/11111111,state=core:NameState text="Fenetre \"sdb\", enfants" 1574106269793000000
+`, InfluxLines(testEvent))
+	assert.Equal(t, "", InfluxLines(&kizcool.GatewayAliveEvent{}))
+}
+
+func TestOpenInfluxFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "states.lp")
+	s, err := Open("influx:" + path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Record(testEvent))
+	assert.NoError(t, s.Record(&kizcool.GatewayAliveEvent{}))
+	assert.NoError(t, s.Close())
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+}
+
+func TestOpenInfluxHTTP(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/api/v2/write?bucket=kizcool&org=home", req.URL.String())
+		assert.Equal(t, "Token secret", req.Header.Get("Authorization"))
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	s, err := Open("influx://" + strings.TrimPrefix(server.URL, "http://") + "/api/v2/write?org=home&bucket=kizcool&token=secret")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Record(testEvent))
+	assert.Equal(t, InfluxLines(testEvent), body)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{"error": "field type conflict"}`))
+	}))
+	defer failing.Close()
+	s = NewInfluxHTTP(failing.URL, "", failing.Client())
+	err = s.Record(testEvent)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "field type conflict")
+}
+
+func TestOpenNDJSONRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.json")
+	s, err := Open("ndjson:" + path + "?max-size=1KB&max-files=2")
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Record(testEvent))
+	}
+	assert.NoError(t, s.Close())
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		assert.NoError(t, err)
+		assert.True(t, info.Size() <= 1024)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"timestamp":1574106269793`))
+}
+
+func TestOpenSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := Open("sqlite:" + filepath.Join(dir, "events.db"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Record(testEvent))
+	assert.NoError(t, s.Close())
+}
+
+func TestOpenErrors(t *testing.T) {
+	for _, spec := range []string{"bogus:x", "influx:", "ndjson:x?max-size=big", "ndjson:x?max-files=many"} {
+		_, err := Open(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	n, err := parseSize("10MB")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10<<20), n)
+	n, err = parseSize("512")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(512), n)
+}
@@ -0,0 +1,140 @@
+package kizcool
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// State names captured by snapshots
+const (
+	StateClosure        StateName = "core:ClosureState"
+	StateLightIntensity StateName = "core:LightIntensityState"
+	StateOnOff          StateName = "core:OnOffState"
+)
+
+// Snapshot holds the closure, intensity and on/off states of devices, to restore them later
+type Snapshot struct {
+	Name    string           `json:"name,omitempty"`
+	Time    time.Time        `json:"time"`
+	Devices []DeviceSnapshot `json:"devices"`
+}
+
+// DeviceSnapshot holds the restorable states of a device. States the device does not have are nil.
+type DeviceSnapshot struct {
+	DeviceURL DeviceURL `json:"deviceURL"`
+	Label     string    `json:"label,omitempty"`
+	Closure   *int      `json:"closure,omitempty"`
+	Intensity *int      `json:"intensity,omitempty"`
+	On        *bool     `json:"on,omitempty"`
+}
+
+// SnapshotDevice returns the restorable states of the device, false if it has none
+func SnapshotDevice(device Device) (DeviceSnapshot, bool) {
+	ds := DeviceSnapshot{DeviceURL: device.DeviceURL, Label: device.Label}
+	for _, s := range device.States {
+		switch s.Name {
+		case StateClosure, StateLightIntensity:
+			f, ok := s.Float()
+			if !ok {
+				continue
+			}
+			v := int(math.Round(f))
+			if s.Name == StateClosure {
+				ds.Closure = &v
+			} else {
+				ds.Intensity = &v
+			}
+		case StateOnOff:
+			on := fmt.Sprint(s.Value) == "on"
+			ds.On = &on
+		}
+	}
+	return ds, ds.Closure != nil || ds.Intensity != nil || ds.On != nil
+}
+
+// Snapshot captures the current states of the devices. Devices without restorable states are skipped.
+func (k *Kiz) Snapshot(devices []Device) (Snapshot, error) {
+	current, err := k.GetDevices()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	byURL := make(map[DeviceURL]Device, len(current))
+	for _, d := range current {
+		byURL[d.DeviceURL] = d
+	}
+	snapshot := Snapshot{Time: time.Now()}
+	for _, d := range devices {
+		fresh, ok := byURL[d.DeviceURL]
+		if !ok {
+			return Snapshot{}, fmt.Errorf("Device %s not found", d.DeviceURL)
+		}
+		if ds, ok := SnapshotDevice(fresh); ok {
+			snapshot.Devices = append(snapshot.Devices, ds)
+		}
+	}
+	if len(snapshot.Devices) == 0 {
+		return Snapshot{}, fmt.Errorf("None of the devices has a closure, intensity or on/off state")
+	}
+	return snapshot, nil
+}
+
+// RestoreCommand returns the command bringing the device back to the snapshot state, false if there is none.
+// Closures are restored with setClosure, lights with off, setIntensity or on.
+func RestoreCommand(device Device, ds DeviceSnapshot) (Command, bool) {
+	var candidates []Command
+	if ds.Closure != nil {
+		candidates = append(candidates, Command{Name: CmdSetClosure, Parameters: []int{*ds.Closure}})
+	}
+	if ds.On != nil && !*ds.On {
+		candidates = append(candidates, Command{Name: CmdOff}, Command{Name: CmdSetIntensity, Parameters: []int{0}})
+	} else {
+		if ds.Intensity != nil {
+			candidates = append(candidates, Command{Name: CmdSetIntensity, Parameters: []int{*ds.Intensity}})
+		}
+		if ds.On != nil {
+			candidates = append(candidates, Command{Name: CmdOn})
+		}
+	}
+	for _, c := range candidates {
+		if SupportsCommand(device, c) {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// RestoreActionGroup returns a single action group restoring all the devices of the snapshot.
+// devices must contain the definitions of the devices of the snapshot.
+func RestoreActionGroup(snapshot Snapshot, devices []Device) (ActionGroup, error) {
+	byURL := make(map[DeviceURL]Device, len(devices))
+	for _, d := range devices {
+		byURL[d.DeviceURL] = d
+	}
+	ag := ActionGroup{Label: "Restore " + snapshot.Name}
+	for _, ds := range snapshot.Devices {
+		device, ok := byURL[ds.DeviceURL]
+		if !ok {
+			return ActionGroup{}, fmt.Errorf("Device %s (%s) of the snapshot no longer exists", ds.Label, ds.DeviceURL)
+		}
+		command, ok := RestoreCommand(device, ds)
+		if !ok {
+			return ActionGroup{}, fmt.Errorf("Device %s does not support restoring its state", device.Label)
+		}
+		ag.Actions = append(ag.Actions, Action{DeviceURL: device.DeviceURL, Commands: []Command{command}})
+	}
+	return ag, nil
+}
+
+// Restore brings the devices back to the states of the snapshot, in a single execution
+func (k *Kiz) Restore(snapshot Snapshot) (ExecID, error) {
+	devices, err := k.GetDevices()
+	if err != nil {
+		return "", err
+	}
+	ag, err := RestoreActionGroup(snapshot, devices)
+	if err != nil {
+		return "", err
+	}
+	return k.Execute(ag)
+}
@@ -1,5 +1,11 @@
 package kizcool
 
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
 // StateDefinition describes the fields of a State
 type StateDefinition struct {
 	Type          string
@@ -26,3 +32,100 @@ type DeviceState struct {
 	Type  StateType
 	Value interface{}
 }
+
+// StatePredicate tells if a state has the expected value
+type StatePredicate func(DeviceState) bool
+
+// StateCompare returns a predicate comparing the value of a state with the given value.
+// op can be one of =, ==, !=, <, <=, > or >=. Values are compared as numbers when both
+// can be parsed as numbers, otherwise as strings. Ordering operators never match strings.
+func StateCompare(op, value string) (StatePredicate, error) {
+	want, wantErr := strconv.ParseFloat(value, 64)
+	wantNumeric := wantErr == nil
+	switch op {
+	case "=", "==":
+		return func(s DeviceState) bool {
+			if got, ok := stateFloat(s); ok && wantNumeric {
+				return got == want
+			}
+			return fmt.Sprint(s.Value) == value
+		}, nil
+	case "!=":
+		return func(s DeviceState) bool {
+			if got, ok := stateFloat(s); ok && wantNumeric {
+				return got != want
+			}
+			return fmt.Sprint(s.Value) != value
+		}, nil
+	case "<", "<=", ">", ">=":
+		if !wantNumeric {
+			return nil, fmt.Errorf("Operator %s needs a numeric value, got %q", op, value)
+		}
+		return func(s DeviceState) bool {
+			got, ok := stateFloat(s)
+			if !ok {
+				return false
+			}
+			switch op {
+			case "<":
+				return got < want
+			case "<=":
+				return got <= want
+			case ">":
+				return got > want
+			default:
+				return got >= want
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown comparison operator: %s", op)
+	}
+}
+
+// Float returns the value of the state as a number, if possible
+func (s DeviceState) Float() (float64, bool) {
+	return stateFloat(s)
+}
+
+// stateFloat returns the value of the state as a number, if possible
+func stateFloat(s DeviceState) (float64, bool) {
+	switch v := s.Value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// StateChange is the value taken by a device state at a given time
+type StateChange struct {
+	Time      time.Time
+	DeviceURL DeviceURL
+	Name      StateName
+	Type      StateType
+	Value     interface{}
+}
+
+// StateChanges returns the state changes carried by the event, if any.
+// The time of the changes is the time of the event.
+func StateChanges(e Event) []StateChange {
+	dsce, ok := e.(*DeviceStateChangedEvent)
+	if !ok {
+		return nil
+	}
+	var changes []StateChange
+	for _, s := range dsce.DeviceStates {
+		changes = append(changes, StateChange{
+			Time:      EventTime(e),
+			DeviceURL: dsce.DeviceURL,
+			Name:      s.Name,
+			Type:      s.Type,
+			Value:     s.Value,
+		})
+	}
+	return changes
+}
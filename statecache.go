@@ -0,0 +1,124 @@
+package kizcool
+
+import (
+	"sort"
+	"sync"
+)
+
+// StateCache keeps the last known states of devices and gateways, updated from events.
+// It is safe for concurrent use.
+type StateCache struct {
+	mux      sync.RWMutex
+	devices  map[DeviceURL]Device
+	gateways map[string]bool
+}
+
+// NewStateCache returns a cache initialized with the devices and gateways of the setup
+func NewStateCache(setup Setup) *StateCache {
+	c := StateCache{
+		devices:  make(map[DeviceURL]Device),
+		gateways: make(map[string]bool),
+	}
+	for _, d := range setup.Devices {
+		c.devices[d.DeviceURL] = d
+	}
+	for _, g := range setup.Gateways {
+		c.gateways[g.GatewayID] = g.Alive
+	}
+	return &c
+}
+
+// Update applies the event to the cache. It returns true if the cache was changed.
+// Events for unknown devices are ignored.
+func (c *StateCache) Update(e Event) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch t := e.(type) {
+	case *DeviceStateChangedEvent:
+		d, ok := c.devices[t.DeviceURL]
+		if !ok {
+			return false
+		}
+		// copy the states so that devices returned earlier are not modified
+		states := append([]DeviceState{}, d.States...)
+		for _, changed := range t.DeviceStates {
+			found := false
+			for i := range states {
+				if states[i].Name == changed.Name {
+					states[i] = changed
+					found = true
+					break
+				}
+			}
+			if !found {
+				states = append(states, changed)
+			}
+		}
+		d.States = states
+		c.devices[t.DeviceURL] = d
+	case *DeviceAvailableEvent:
+		return c.setAvailable(t.DeviceURL, true)
+	case *DeviceUnavailableEvent:
+		return c.setAvailable(t.DeviceURL, false)
+	case *GatewayAliveEvent:
+		c.gateways[t.GatewayID] = true
+	case *GatewayDownEvent:
+		c.gateways[t.GatewayID] = false
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *StateCache) setAvailable(url DeviceURL, available bool) bool {
+	d, ok := c.devices[url]
+	if !ok {
+		return false
+	}
+	d.Available = available
+	c.devices[url] = d
+	return true
+}
+
+// Devices returns all devices with their last known states, sorted by URL
+func (c *StateCache) Devices() []Device {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	devices := make([]Device, 0, len(c.devices))
+	for _, d := range c.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceURL < devices[j].DeviceURL })
+	return devices
+}
+
+// Device returns the device with its last known states
+func (c *StateCache) Device(url DeviceURL) (Device, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	d, ok := c.devices[url]
+	return d, ok
+}
+
+// State returns the last known value of a device state
+func (c *StateCache) State(url DeviceURL, name StateName) (DeviceState, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	for _, s := range c.devices[url].States {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return DeviceState{}, false
+}
+
+// Gateways returns whether each gateway is alive, by gateway id
+func (c *StateCache) Gateways() map[string]bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	gateways := make(map[string]bool, len(c.gateways))
+	for id, alive := range c.gateways {
+		gateways[id] = alive
+	}
+	return gateways
+}
@@ -0,0 +1,263 @@
+package kizcool
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TextPrinter is implemented by types having a readable text representation.
+// It is used by Output with the text format.
+type TextPrinter interface {
+	PrintText(w io.Writer) error
+}
+
+// stateInfo describes the unit and possible values of well-known states
+type stateInfo struct {
+	unit   string
+	values []string
+}
+
+var knownStates = map[StateName]stateInfo{
+	"core:ClosureState":                   {unit: "%"},
+	"core:TargetClosureState":             {unit: "%"},
+	"core:DeploymentState":                {unit: "%"},
+	"core:LightIntensityState":            {unit: "%"},
+	"core:RSSILevelState":                 {unit: "%"},
+	"core:PriorityLockTimerState":         {unit: "s"},
+	"core:TemperatureState":               {unit: "°C"},
+	"core:TargetTemperatureState":         {unit: "°C"},
+	"core:LuminanceState":                 {unit: "lx"},
+	"core:RelativeHumidityState":          {unit: "%"},
+	"core:ElectricEnergyConsumptionState": {unit: "Wh"},
+	"core:ElectricPowerConsumptionState":  {unit: "W"},
+	"core:BatteryLevelState":              {unit: "%"},
+	"internal:AlarmDelayState":            {unit: "s"},
+	"core:OpenClosedState":                {values: []string{"closed", "open"}},
+	"core:OnOffState":                     {values: []string{"off", "on"}},
+	"core:StatusState":                    {values: []string{"available", "unavailable"}},
+	"core:ConnectivityState":              {values: []string{"offline", "online"}},
+	"core:ContactState":                   {values: []string{"closed", "open"}},
+	"core:OccupancyState":                 {values: []string{"noPersonInside", "personInside"}},
+	"core:RainState":                      {values: []string{"notDetected", "detected"}},
+	"internal:CurrentAlarmModeState":      {values: []string{"off", "partial1", "partial2", "total"}},
+	"internal:TargetAlarmModeState":       {values: []string{"off", "partial1", "partial2", "sos", "total"}},
+	"internal:IntrusionDetectedState":     {values: []string{"detected", "notDetected", "pending", "sos"}},
+}
+
+// PrintText prints the label, url and main states of the device on one line
+func (d Device) PrintText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "| %-22s | %-33s | %s\n", d.Label, d.DeviceURL, stateSummary(d.States))
+	return err
+}
+
+// PrintText prints the name and value of the state, with its unit or possible values if known
+func (s DeviceState) PrintText(w io.Writer) error {
+	value := fmt.Sprint(s.Value)
+	if info, ok := knownStates[s.Name]; ok {
+		if info.unit != "" {
+			value += " " + info.unit
+		}
+		if len(info.values) > 0 {
+			value = fmt.Sprintf("%-12s (%s)", value, strings.Join(info.values, "|"))
+		}
+	}
+	_, err := fmt.Fprintf(w, "%-33s %s\n", s.Name, value)
+	return err
+}
+
+// PrintText prints the time, device and value of the state change on one line
+func (c StateChange) PrintText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s %s %s=%v\n", c.Time.Format(time.RFC3339), c.DeviceURL, c.Name, c.Value)
+	return err
+}
+
+// PrintText prints the action group with the commands sent to each device
+func (ag ActionGroup) PrintText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s (%s)\n", ag.Label, ag.OID); err != nil {
+		return err
+	}
+	return printTextActions(w, ag.Actions, "  ")
+}
+
+// PrintText prints the execution state and its actions
+func (e Execution) PrintText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s %s %q started %s by %s\n", e.ID, e.State, e.ActionGroup.Label,
+		msTime(e.StartTime).Format(time.RFC3339), e.Owner); err != nil {
+		return err
+	}
+	return printTextActions(w, e.ActionGroup.Actions, "  ")
+}
+
+// PrintText prints the snapshot with the saved states of each device
+func (s Snapshot) PrintText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s (%s)\n", s.Name, s.Time.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	for _, d := range s.Devices {
+		var states []string
+		if d.Closure != nil {
+			states = append(states, fmt.Sprintf("closure %d%%", *d.Closure))
+		}
+		if d.On != nil {
+			states = append(states, map[bool]string{true: "on", false: "off"}[*d.On])
+		}
+		if d.Intensity != nil {
+			states = append(states, fmt.Sprintf("intensity %d%%", *d.Intensity))
+		}
+		if _, err := fmt.Fprintf(w, "  %s: %s\n", d.Label, strings.Join(states, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintText prints the group and its members on one line
+func (g Group) PrintText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s%s: %s\n", GroupPrefix, g.Name, strings.Join(g.Members, ", "))
+	return err
+}
+
+// PrintText prints the id of the execution
+func (id ExecID) PrintText(w io.Writer) error {
+	_, err := fmt.Fprintln(w, id)
+	return err
+}
+
+// PrintText prints the location, gateways and places of the setup with the number of devices in each
+func (s Setup) PrintText(w io.Writer) error {
+	l := s.Location
+	if _, err := fmt.Fprintf(w, "Setup %s\nLocation: %s, %s %s, %s (%s) lat %.3f lon %.3f\n", s.ID,
+		l.AddressLine1, l.PostalCode, l.City, l.Country, l.Timezone, l.Latitude, l.Longitude); err != nil {
+		return err
+	}
+	for _, g := range s.Gateways {
+		status := "down"
+		if g.Alive {
+			status = "alive"
+		}
+		if _, err := fmt.Fprintf(w, "Gateway %s: %s, %s, %s\n", g.GatewayID, status, g.Connectivity.Status, g.UpdateStatus); err != nil {
+			return err
+		}
+	}
+	count := make(map[string]int)
+	for _, d := range s.Devices {
+		count[d.PlaceOID]++
+	}
+	if _, err := fmt.Fprintf(w, "Devices: %d\n", len(s.Devices)); err != nil {
+		return err
+	}
+	return printTextPlace(w, s.RootPlace, count, "")
+}
+
+// PrintText prints the place and its sub-places as a tree
+func (p Place) PrintText(w io.Writer) error {
+	return printTextPlace(w, p, nil, "")
+}
+
+func printTextPlace(w io.Writer, p Place, count map[string]int, indent string) error {
+	line := indent + p.Label
+	if count != nil {
+		line += fmt.Sprintf(" (%d)", count[p.OID])
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	for _, sub := range p.SubPlaces {
+		if err := printTextPlace(w, sub, count, indent+"  "); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTextActions prints one line per action with the device and its commands
+func printTextActions(w io.Writer, actions []Action, indent string) error {
+	for _, a := range actions {
+		var commands []string
+		for _, c := range a.Commands {
+			commands = append(commands, commandText(c))
+		}
+		if _, err := fmt.Fprintf(w, "%s%s: %s\n", indent, a.DeviceURL, strings.Join(commands, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandText prints a command as name(parameters)
+func commandText(c Command) string {
+	params := fmt.Sprint(c.Parameters)
+	if c.Parameters == nil || params == "[]" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Trim(params, "[]"))
+}
+
+// msTime converts a timestamp in milliseconds to a time
+func msTime(ms int) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
+
+// printTextEvent prints an event on a single line: time, name, devices and details
+func printTextEvent(w io.Writer, e Event) error {
+	line := fmt.Sprintf("%s %s", EventTime(e).Format(time.RFC3339), EventName(e))
+	if urls := EventDeviceURLs(e); len(urls) > 0 {
+		var s []string
+		for _, url := range urls {
+			s = append(s, string(url))
+		}
+		line += " " + strings.Join(s, ",")
+	}
+	if summary := eventSummary(e); summary != "" {
+		line += " " + summary
+	}
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
+
+// PrintText prints the event on one line
+func (e *ExecutionRegisteredEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *ExecutionStateChangedEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *CommandExecutionStateChangedEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *GatewaySynchronizationStartedEvent) PrintText(w io.Writer) error {
+	return printTextEvent(w, e)
+}
+
+// PrintText prints the event on one line
+func (e *GatewaySynchronizationEndedEvent) PrintText(w io.Writer) error {
+	return printTextEvent(w, e)
+}
+
+// PrintText prints the event on one line
+func (e *GatewayDownEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *GatewayAliveEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *RefreshAllDevicesStatesCompletedEvent) PrintText(w io.Writer) error {
+	return printTextEvent(w, e)
+}
+
+// PrintText prints the event on one line
+func (e *DeviceStateChangedEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *EndUserLoginEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *DeviceAvailableEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }
+
+// PrintText prints the event on one line
+func (e *DeviceUnavailableEvent) PrintText(w io.Writer) error { return printTextEvent(w, e) }